@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/service"
+)
+
+// importSQLite implements the import subcommand. It loads the data files, or
+// the embedded dataset, and replaces the content of the SQLite file with
+// them, creating it if needed. It exits with 2 if anything fails.
+func importSQLite(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dataFiles := dataFlag(flags)
+	sqlitePath := sqliteFlag(flags)
+	flags.Parse(args)
+
+	if *sqlitePath == "" {
+		fmt.Fprintln(os.Stderr, "import: -sqlite is required")
+		flags.Usage()
+		return 2
+	}
+
+	destinations, err := dataset(splitPaths(*dataFiles))()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	db, err := service.OpenSQLite(*sqlitePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer db.Close()
+
+	if err := db.Import(context.Background(), destinations); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("imported %d destinations into %s\n", len(destinations), *sqlitePath)
+	return 0
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+	"time"
 
+	embedded "github.com/bee-travels/bee-travels-go/services/destination-v1/data"
 	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
 	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/handler"
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/loader"
 	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/service"
 	"github.com/labstack/echo-contrib/prometheus"
 	"github.com/labstack/echo/v4"
@@ -14,6 +20,19 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(importSQLite(os.Args[2:]))
+	}
+
+	dataFiles := dataFlag(flag.CommandLine)
+	providerName := flag.String("provider", envDefault("DESTINATIONS_PROVIDER", "file"),
+		"where destinations are read from: file, sqlite or postgres (env DESTINATIONS_PROVIDER)")
+	sqlitePath := sqliteFlag(flag.CommandLine)
+	flag.Parse()
+
 	e := echo.New()
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "method=${method}, uri=${uri}, status=${status}, time=${latency_human}\n",
@@ -22,9 +41,41 @@ func main() {
 	p := prometheus.NewPrometheus("echo", nil)
 	p.Use(e)
 
-	d := loadData("./data/destinations.json")
+	var provider data.DataProvider
+	switch *providerName {
+	case "file":
+		reloader, err := newProvider(splitPaths(*dataFiles))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go reloader.Watch(context.Background(), reloadInterval())
+		provider = reloader
+
+		r := handler.NewReloadHandler(reloader)
 
-	provider := service.NewLocalDB(d)
+		// The admin routes need ADMIN_TOKEN, sent as a bearer token; they
+		// are all refused when it is unset.
+		admin := e.Group("/admin", handler.AdminOnly(os.Getenv("ADMIN_TOKEN")))
+
+		admin.GET("/data/status", r.GetStatus)
+		admin.POST("/data/reload", r.Reload)
+	case "sqlite":
+		db, err := newSQLiteProvider(*sqlitePath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer db.Close()
+		provider = db
+	case "postgres":
+		db, err := newPostgresProvider()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer db.Close()
+		provider = db
+	default:
+		log.Fatalf("unknown provider %q, expected file, sqlite or postgres", *providerName)
+	}
 
 	h := handler.New(provider)
 
@@ -37,18 +88,159 @@ func main() {
 	e.Logger.Fatal(e.Start(":9001"))
 }
 
-func loadData(filename string) []data.Destination {
-	b, err := ioutil.ReadFile(filename)
+// newProvider serves the given files when there are any, and the dataset
+// compiled into the binary otherwise.
+func newProvider(paths []string) (*service.Reloader, error) {
+	provider, err := service.NewReloader(dataset(paths), paths...)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		log.Printf("serving %d destinations from the embedded dataset", provider.Status().Count)
+	} else {
+		log.Printf("serving %d destinations from %s", provider.Status().Count, strings.Join(paths, ", "))
+	}
+	return provider, nil
+}
+
+// newSQLiteProvider serves the destinations imported into the SQLite file at
+// path.
+func newSQLiteProvider(path string) (*service.SQLiteDB, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the sqlite provider needs -sqlite or DESTINATIONS_SQLITE")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := service.OpenSQLite(path)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
+	count, err := db.Count(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Printf("serving %d destinations from %s", count, path)
+	return db, nil
+}
 
-	var res []data.Destination
+// newPostgresProvider serves the destination table of destination-v2, from
+// the database named by the same PG_* variables.
+func newPostgresProvider() (*service.PostgresDB, error) {
+	connString, err := postgresConnString()
+	if err != nil {
+		return nil, err
+	}
 
-	err = json.Unmarshal(b, &res)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
 
+	db, err := service.ConnectPostgres(ctx, connString)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+	count, err := db.Count(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Printf("serving %d destinations from postgres", count)
+	return db, nil
+}
+
+// postgresConnString reads the same variables as destination-v2: PG_HOST,
+// PG_PORT, PG_USER and PG_PASSWORD are required, PG_DATABASE defaults to
+// beetravels and PG_SSLMODE to prefer.
+func postgresConnString() (string, error) {
+	settings := make([]string, 0, 6)
+	for _, setting := range []struct{ key, env string }{
+		{"host", "PG_HOST"}, {"port", "PG_PORT"}, {"user", "PG_USER"}, {"password", "PG_PASSWORD"},
+	} {
+		value, found := os.LookupEnv(setting.env)
+		if !found {
+			return "", fmt.Errorf("%s must be set for the postgres provider", setting.env)
+		}
+		settings = append(settings, setting.key+"="+quoteConnValue(value))
+	}
+	settings = append(settings,
+		"dbname="+quoteConnValue(envDefault("PG_DATABASE", "beetravels")),
+		"sslmode="+quoteConnValue(envDefault("PG_SSLMODE", "prefer")),
+	)
+	return strings.Join(settings, " "), nil
+}
+
+// quoteConnValue quotes a value of a keyword/value connection string, so
+// that a password with spaces or quotes stays one value.
+func quoteConnValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// dataset loads the given files, or the dataset compiled into the binary when
+// there are none.
+func dataset(paths []string) service.LoadFunc {
+	if len(paths) == 0 {
+		return func() ([]data.Destination, error) {
+			destinations, err := loader.ParseJSON("embedded dataset", embedded.Destinations)
+			if err != nil {
+				return nil, err
+			}
+			return destinations, loader.Validate(destinations)
+		}
+	}
+	return func() ([]data.Destination, error) {
+		res, err := loader.Load(paths...)
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range res.Notes {
+			log.Println(note)
+		}
+		return res.Destinations, nil
+	}
+}
+
+func dataFlag(flags *flag.FlagSet) *string {
+	return flags.String("data", os.Getenv("DESTINATIONS_FILE"),
+		"comma-separated destination files or directories to serve instead of the embedded dataset; "+
+			"later files override earlier ones by id (env DESTINATIONS_FILE)")
+}
+
+func sqliteFlag(flags *flag.FlagSet) *string {
+	return flags.String("sqlite", os.Getenv("DESTINATIONS_SQLITE"),
+		"SQLite file served by the sqlite provider and written by import (env DESTINATIONS_SQLITE)")
+}
+
+// envDefault returns the environment variable key, or fallback when it is
+// unset or empty.
+func envDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func splitPaths(value string) []string {
+	var paths []string
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// reloadInterval reads DATA_RELOAD_INTERVAL, defaulting to 10 seconds.
+func reloadInterval() time.Duration {
+	value, ok := os.LookupEnv("DATA_RELOAD_INTERVAL")
+	if !ok {
+		return 10 * time.Second
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Fatalf("invalid DATA_RELOAD_INTERVAL %q", value)
 	}
-	return res
+	return interval
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestPostgresConnString(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		unset        string
+		wantDatabase string
+		wantPassword string
+		wantTLS      bool
+		wantErr      bool
+	}{
+		{
+			name:         "defaults",
+			env:          map[string]string{"PG_PASSWORD": "secret"},
+			wantDatabase: "beetravels",
+			wantPassword: "secret",
+			wantTLS:      true,
+		},
+		{
+			name:         "database and TLS mode",
+			env:          map[string]string{"PG_PASSWORD": "secret", "PG_DATABASE": "destinations", "PG_SSLMODE": "disable"},
+			wantDatabase: "destinations",
+			wantPassword: "secret",
+		},
+		{
+			name:         "quoted password",
+			env:          map[string]string{"PG_PASSWORD": `it's a \ secret`, "PG_SSLMODE": "require"},
+			wantDatabase: "beetravels",
+			wantPassword: `it's a \ secret`,
+			wantTLS:      true,
+		},
+		{
+			name:    "missing host",
+			env:     map[string]string{"PG_PASSWORD": "secret"},
+			unset:   "PG_HOST",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PG_HOST", "db.example.com")
+			t.Setenv("PG_PORT", "5433")
+			t.Setenv("PG_USER", "bee")
+			t.Setenv("PG_DATABASE", "")
+			t.Setenv("PG_SSLMODE", "")
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			if test.unset != "" {
+				os.Unsetenv(test.unset)
+			}
+
+			connString, err := postgresConnString()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("postgresConnString() error = %v, want error: %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+
+			config, err := pgx.ParseConfig(connString)
+			if err != nil {
+				t.Fatalf("can't parse %q: %v", connString, err)
+			}
+			if config.Host != "db.example.com" || config.Port != 5433 || config.User != "bee" {
+				t.Errorf("connects to %s@%s:%d, want bee@db.example.com:5433", config.User, config.Host, config.Port)
+			}
+			if config.Database != test.wantDatabase {
+				t.Errorf("database = %q, want %q", config.Database, test.wantDatabase)
+			}
+			if config.Password != test.wantPassword {
+				t.Errorf("password = %q, want %q", config.Password, test.wantPassword)
+			}
+			if (config.TLSConfig != nil) != test.wantTLS {
+				t.Errorf("TLS = %v, want %v", config.TLSConfig != nil, test.wantTLS)
+			}
+		})
+	}
+}
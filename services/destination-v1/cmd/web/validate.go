@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	embedded "github.com/bee-travels/bee-travels-go/services/destination-v1/data"
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/loader"
+	"github.com/bee-travels/bee-travels-go/services/shared/validation"
+)
+
+// validate implements the validate subcommand. It prints a JSON report to
+// stdout and exits with 1 if the dataset has issues, or 2 if it couldn't be
+// checked at all.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	dataFiles := dataFlag(flags)
+	flags.Parse(args)
+
+	var items []validation.Item
+	var report *validation.Report
+
+	paths := splitPaths(*dataFiles)
+	if len(paths) == 0 {
+		report = validation.NewReport("embedded dataset")
+		items = parseItems(report, "embedded dataset", embedded.Destinations, loader.ParseJSON)
+	} else {
+		files, err := loader.Expand(paths...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		report = validation.NewReport(files...)
+		for _, filename := range files {
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			items = append(items, parseItems(report, filename, b, loader.Parse)...)
+		}
+	}
+
+	validation.Check(report, items)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+func parseItems(report *validation.Report, name string, b []byte, parse func(string, []byte) ([]data.Destination, error)) []validation.Item {
+	destinations, err := parse(name, b)
+	if err != nil {
+		report.Add(validation.Issue{
+			Rule:    validation.ParseError,
+			Source:  name,
+			Message: err.Error(),
+		})
+		return nil
+	}
+
+	items := make([]validation.Item, len(destinations))
+	for i, destination := range destinations {
+		items[i] = validation.Item{Source: name, Index: i, Destination: validation.Destination(destination)}
+	}
+	return items
+}
@@ -0,0 +1,8 @@
+// Package data holds the destination catalogue that is compiled into the
+// service and served when no external file is configured.
+package data
+
+import _ "embed"
+
+//go:embed destinations.json
+var Destinations []byte
@@ -0,0 +1,18 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/data"
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/loader"
+)
+
+func TestEmbeddedDatasetIsValid(t *testing.T) {
+	destinations, err := loader.ParseJSON("embedded dataset", data.Destinations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Validate(destinations); err != nil {
+		t.Error(err)
+	}
+}
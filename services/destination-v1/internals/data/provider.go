@@ -1,7 +1,9 @@
 package data
 
+import "context"
+
 type DataProvider interface {
-	ByCityCountry(city string, country string) Destination
-	ByCountry(country string) []Destination
-	All() []DestinationList
+	ByCityCountry(ctx context.Context, city string, country string) (Destination, error)
+	ByCountry(ctx context.Context, country string) ([]Destination, error)
+	All(ctx context.Context) ([]DestinationList, error)
 }
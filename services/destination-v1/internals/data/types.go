@@ -1,14 +1,14 @@
 package data
 
 type Destination struct {
-	ID          string   `json:"id"`
-	City        string   `json:"city"`
-	Country     string   `json:"country"`
-	Latitude    float64  `json:"latitude"`
-	Longitude   float64  `json:"longitude"`
-	Population  int      `json:"population"`
-	Description string   `json:"description"`
-	Images      []string `json:"images"`
+	ID          string   `json:"id" yaml:"id"`
+	City        string   `json:"city" yaml:"city"`
+	Country     string   `json:"country" yaml:"country"`
+	Latitude    float64  `json:"latitude" yaml:"latitude"`
+	Longitude   float64  `json:"longitude" yaml:"longitude"`
+	Population  int      `json:"population" yaml:"population"`
+	Description string   `json:"description" yaml:"description"`
+	Images      []string `json:"images" yaml:"images"`
 }
 
 type DestinationList struct {
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminOnly refuses requests that don't carry token as a bearer token.
+// Nobody is an admin when token is empty.
+func AdminOnly(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !isAdmin(c.Request().Header.Get("Authorization"), token) {
+				return echo.NewHTTPError(http.StatusForbidden, "admin token required")
+			}
+			return next(c)
+		}
+	}
+}
+
+func isAdmin(header, token string) bool {
+	if token == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAdminOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "matching token", token: "secret", header: "Bearer secret", want: http.StatusOK},
+		{name: "wrong token", token: "secret", header: "Bearer guess", want: http.StatusForbidden},
+		{name: "no header", token: "secret", want: http.StatusForbidden},
+		{name: "not a bearer token", token: "secret", header: "Basic secret", want: http.StatusForbidden},
+		{name: "token unset", header: "Bearer ", want: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.POST("/admin/data/reload", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, AdminOnly(test.token))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/data/reload", nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != test.want {
+				t.Errorf("status = %d, want %d", rec.Code, test.want)
+			}
+		})
+	}
+}
@@ -9,17 +9,26 @@ import (
 func (h *Handler) GetDestinationByCityCountry(c echo.Context) error {
 	city := c.Param("city")
 	country := c.Param("country")
-	destination := h.db.ByCityCountry(city, country)
+	destination, err := h.db.ByCityCountry(c.Request().Context(), city, country)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, destination)
 }
 
 func (h *Handler) GetDestinationByCountry(c echo.Context) error {
 	country := c.Param("country")
-	destinations := h.db.ByCountry(country)
+	destinations, err := h.db.ByCountry(c.Request().Context(), country)
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, destinations)
 }
 
 func (h *Handler) GetDestinations(c echo.Context) error {
-	destinations := h.db.All()
+	destinations, err := h.db.All(c.Request().Context())
+	if err != nil {
+		return err
+	}
 	return c.JSON(http.StatusOK, destinations)
 }
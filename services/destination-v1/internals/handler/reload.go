@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/service"
+	"github.com/labstack/echo/v4"
+)
+
+type ReloadHandler struct {
+	reloader *service.Reloader
+}
+
+func NewReloadHandler(reloader *service.Reloader) *ReloadHandler {
+	return &ReloadHandler{reloader: reloader}
+}
+
+func (h *ReloadHandler) GetStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.reloader.Status())
+}
+
+func (h *ReloadHandler) Reload(c echo.Context) error {
+	if err := h.reloader.Reload(); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, h.reloader.Status())
+	}
+	return c.JSON(http.StatusOK, h.reloader.Status())
+}
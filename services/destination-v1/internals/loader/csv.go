@@ -0,0 +1,133 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+// imageSeparator splits the URLs held in the images column.
+const imageSeparator = "|"
+
+var csvColumns = []string{"id", "city", "country", "latitude", "longitude", "population", "description", "images"}
+
+// ParseCSV decodes a spreadsheet export. The first row names the columns,
+// in any order; id, city and country are required, and images holds any
+// number of URLs separated by "|".
+func ParseCSV(name string, b []byte) ([]data.Destination, error) {
+	destinations, _, err := parseCSV(name, b)
+	return destinations, err
+}
+
+func parseCSV(name string, b []byte) ([]data.Destination, []int, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, csvError(name, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !knownColumn(column) {
+			return nil, nil, fmt.Errorf("%s:1: unknown column %q", name, column)
+		}
+		if _, ok := columns[column]; ok {
+			return nil, nil, fmt.Errorf("%s:1: duplicate column %q", name, column)
+		}
+		columns[column] = i
+	}
+	for _, column := range []string{"id", "city", "country"} {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("%s:1: missing column %q", name, column)
+		}
+	}
+
+	res := make([]data.Destination, 0)
+	lines := make([]int, 0)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return res, lines, nil
+		}
+		if err != nil {
+			return nil, nil, csvError(name, err)
+		}
+
+		line, _ := r.FieldPos(0)
+		destination, err := destinationFromRecord(record, columns)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		res = append(res, destination)
+		lines = append(lines, line)
+	}
+}
+
+func destinationFromRecord(record []string, columns map[string]int) (data.Destination, error) {
+	field := func(column string) string {
+		if i, ok := columns[column]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	destination := data.Destination{
+		ID:          field("id"),
+		City:        field("city"),
+		Country:     field("country"),
+		Description: field("description"),
+		Images:      make([]string, 0),
+	}
+
+	var err error
+	if value := field("latitude"); value != "" {
+		if destination.Latitude, err = strconv.ParseFloat(value, 64); err != nil {
+			return destination, fmt.Errorf("invalid latitude %q", value)
+		}
+	}
+	if value := field("longitude"); value != "" {
+		if destination.Longitude, err = strconv.ParseFloat(value, 64); err != nil {
+			return destination, fmt.Errorf("invalid longitude %q", value)
+		}
+	}
+	if value := field("population"); value != "" {
+		if destination.Population, err = strconv.Atoi(value); err != nil {
+			return destination, fmt.Errorf("invalid population %q", value)
+		}
+	}
+	for _, image := range strings.Split(field("images"), imageSeparator) {
+		if image = strings.TrimSpace(image); image != "" {
+			destination.Images = append(destination.Images, image)
+		}
+	}
+	return destination, nil
+}
+
+func knownColumn(column string) bool {
+	for _, known := range csvColumns {
+		if known == column {
+			return true
+		}
+	}
+	return false
+}
+
+func csvError(name string, err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%s:%d: %w", name, parseErr.Line, parseErr.Err)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
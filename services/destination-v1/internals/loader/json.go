@@ -0,0 +1,63 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+// ParseJSON decodes a JSON array of destinations.
+func ParseJSON(name string, b []byte) ([]data.Destination, error) {
+	destinations, _, err := parseJSON(name, b)
+	return destinations, err
+}
+
+func parseJSON(name string, b []byte) ([]data.Destination, []int, error) {
+	var res []data.Destination
+	if err := json.Unmarshal(b, &res); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return nil, nil, fmt.Errorf("%s:%d: %w", name, lineAt(b, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return nil, nil, fmt.Errorf("%s:%d: %w", name, lineAt(b, typeErr.Offset), err)
+		}
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return res, elementLines(b, len(res)), nil
+}
+
+// elementLines returns the line each of the n elements of the JSON array in
+// b starts on. b has already been decoded, so it is known to be valid.
+func elementLines(b []byte, n int) []int {
+	lines := make([]int, 0, n)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if _, err := decoder.Token(); err != nil {
+		return lines
+	}
+	for decoder.More() {
+		start := decoder.InputOffset()
+		for start < int64(len(b)) && strings.ContainsRune(" \t\r\n,", rune(b[start])) {
+			start++
+		}
+		lines = append(lines, lineAt(b, start))
+		var element json.RawMessage
+		if err := decoder.Decode(&element); err != nil {
+			break
+		}
+	}
+	return lines
+}
+
+// lineAt returns the 1-based line containing the given byte offset.
+func lineAt(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
@@ -0,0 +1,214 @@
+package loader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+// Layer is the content of a single data file.
+type Layer struct {
+	Name         string
+	Destinations []data.Destination
+}
+
+// Note describes an override or a conflict found while merging layers.
+type Note struct {
+	Kind    string
+	ID      string
+	Message string
+}
+
+func (n Note) String() string {
+	return fmt.Sprintf("%s %s: %s", n.Kind, n.ID, n.Message)
+}
+
+const (
+	Override = "override"
+	Conflict = "conflict"
+)
+
+// Result is a merged, validated dataset.
+type Result struct {
+	Destinations []data.Destination
+	Notes        []Note
+}
+
+// Load reads every file named by paths, expanding directories to the data
+// files they contain, and merges them in order so that later files override
+// earlier ones by id.
+func Load(paths ...string) (Result, error) {
+	files, err := Expand(paths...)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(files) == 0 {
+		return Result{}, fmt.Errorf("no data files found in %v", paths)
+	}
+
+	layers := make([]Layer, 0, len(files))
+	for _, filename := range files {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return Result{}, err
+		}
+		destinations, lines, err := parseLines(filename, b)
+		if err != nil {
+			return Result{}, err
+		}
+		if err := validateEntries(destinations, func(i int) string {
+			return fmt.Sprintf("%s:%d", filename, lines[i])
+		}); err != nil {
+			return Result{}, err
+		}
+		layers = append(layers, Layer{Name: filename, Destinations: destinations})
+	}
+
+	res := Merge(layers...)
+	if err := Validate(res.Destinations); err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// Expand replaces every directory in paths with the data files it contains,
+// sorted by name. Files are returned as given.
+func Expand(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if _, ok := parsers[extension(entry.Name())]; ok && !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	return files, nil
+}
+
+// parser decodes a dataset, and returns the line each destination starts
+// on along with it.
+type parser func(name string, b []byte) ([]data.Destination, []int, error)
+
+var parsers = map[string]parser{
+	".json": parseJSON,
+	".csv":  parseCSV,
+	".yaml": parseYAML,
+	".yml":  parseYAML,
+}
+
+// Parse decodes a dataset in the format given by the extension of name,
+// which also prefixes any error.
+func Parse(name string, b []byte) ([]data.Destination, error) {
+	destinations, _, err := parseLines(name, b)
+	return destinations, err
+}
+
+// parseLines is Parse, returning the line each destination starts on too.
+func parseLines(name string, b []byte) ([]data.Destination, []int, error) {
+	parse, ok := parsers[extension(name)]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported format, expected .json, .csv, .yaml or .yml", name)
+	}
+	return parse(name, b)
+}
+
+func extension(name string) string {
+	return strings.ToLower(filepath.Ext(name))
+}
+
+// Merge combines layers in order. A destination replaces any earlier one
+// with the same id and keeps its position; new ids are appended. Replaced
+// ids, and distinct ids sharing a city and country, are reported as notes.
+func Merge(layers ...Layer) Result {
+	var res Result
+	index := make(map[string]int)
+	origin := make(map[string]string)
+
+	for _, layer := range layers {
+		for _, destination := range layer.Destinations {
+			if i, ok := index[destination.ID]; ok {
+				res.Notes = append(res.Notes, Note{
+					Kind:    Override,
+					ID:      destination.ID,
+					Message: fmt.Sprintf("%s, %s from %s replaced by %s", res.Destinations[i].City, res.Destinations[i].Country, origin[destination.ID], layer.Name),
+				})
+				res.Destinations[i] = destination
+			} else {
+				index[destination.ID] = len(res.Destinations)
+				res.Destinations = append(res.Destinations, destination)
+			}
+			origin[destination.ID] = layer.Name
+		}
+	}
+
+	locations := make(map[string]string)
+	for _, destination := range res.Destinations {
+		key := destination.City + "\x00" + destination.Country
+		if id, ok := locations[key]; ok {
+			res.Notes = append(res.Notes, Note{
+				Kind:    Conflict,
+				ID:      destination.ID,
+				Message: fmt.Sprintf("%s, %s from %s is also defined by %s from %s", destination.City, destination.Country, origin[destination.ID], id, origin[id]),
+			})
+			continue
+		}
+		locations[key] = destination.ID
+	}
+	return res
+}
+
+// Validate checks that a dataset is safe to serve: it must not be empty,
+// every destination needs an id, city and country, and ids must be unique.
+func Validate(destinations []data.Destination) error {
+	if len(destinations) == 0 {
+		return fmt.Errorf("dataset contains no destinations")
+	}
+	return validateEntries(destinations, func(i int) string {
+		return fmt.Sprintf("destination %d", i)
+	})
+}
+
+// validateEntries reports the first invalid destination at the position
+// that where gives for its index: a line of the file it was read from, or
+// its index in a dataset that has no file.
+func validateEntries(destinations []data.Destination, where func(i int) string) error {
+	seen := make(map[string]int, len(destinations))
+	for i, destination := range destinations {
+		switch {
+		case destination.ID == "":
+			return fmt.Errorf("%s: missing id", where(i))
+		case destination.City == "":
+			return fmt.Errorf("%s (%s): missing city", where(i), destination.ID)
+		case destination.Country == "":
+			return fmt.Errorf("%s (%s): missing country", where(i), destination.ID)
+		}
+		if prev, ok := seen[destination.ID]; ok {
+			return fmt.Errorf("%s: id %s already used by %s", where(i), destination.ID, where(prev))
+		}
+		seen[destination.ID] = i
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+func destination(id, city, country string) data.Destination {
+	return data.Destination{ID: id, City: city, Country: country}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers []Layer
+		want   []string
+		notes  []string
+	}{
+		{
+			name:   "single layer",
+			layers: []Layer{{Name: "a", Destinations: []data.Destination{destination("1", "Paris", "France"), destination("2", "Rome", "Italy")}}},
+			want:   []string{"1 Paris", "2 Rome"},
+		},
+		{
+			name: "later layer overrides by id in place",
+			layers: []Layer{
+				{Name: "a", Destinations: []data.Destination{destination("1", "Paris", "France"), destination("2", "Rome", "Italy")}},
+				{Name: "b", Destinations: []data.Destination{destination("1", "Lyon", "France")}},
+			},
+			want:  []string{"1 Lyon", "2 Rome"},
+			notes: []string{"override 1: Paris, France from a replaced by b"},
+		},
+		{
+			name: "new ids are appended",
+			layers: []Layer{
+				{Name: "a", Destinations: []data.Destination{destination("1", "Paris", "France")}},
+				{Name: "b", Destinations: []data.Destination{destination("3", "Oslo", "Norway")}},
+			},
+			want: []string{"1 Paris", "3 Oslo"},
+		},
+		{
+			name: "distinct ids for the same location conflict",
+			layers: []Layer{
+				{Name: "a", Destinations: []data.Destination{destination("1", "Paris", "France")}},
+				{Name: "b", Destinations: []data.Destination{destination("2", "Paris", "France")}},
+			},
+			want:  []string{"1 Paris", "2 Paris"},
+			notes: []string{"conflict 2: Paris, France from b is also defined by 1 from a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := Merge(test.layers...)
+
+			var got []string
+			for _, d := range res.Destinations {
+				got = append(got, d.ID+" "+d.City)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("destinations = %v, want %v", got, test.want)
+			}
+
+			var notes []string
+			for _, note := range res.Notes {
+				notes = append(notes, note.String())
+			}
+			if !reflect.DeepEqual(notes, test.notes) {
+				t.Errorf("notes = %q, want %q", notes, test.notes)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []data.Destination
+		wantErr      string
+	}{
+		{name: "valid", destinations: []data.Destination{destination("1", "Paris", "France")}},
+		{name: "empty", wantErr: "no destinations"},
+		{name: "missing id", destinations: []data.Destination{destination("", "Paris", "France")}, wantErr: "missing id"},
+		{name: "missing city", destinations: []data.Destination{destination("1", "", "France")}, wantErr: "missing city"},
+		{name: "missing country", destinations: []data.Destination{destination("1", "Paris", "")}, wantErr: "missing country"},
+		{
+			name:         "duplicate id",
+			destinations: []data.Destination{destination("1", "Paris", "France"), destination("1", "Rome", "Italy")},
+			wantErr:      "id 1 already used by destination 0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.destinations)
+			checkError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	base := write("base.json", `[{"id": "1", "city": "Paris", "country": "France"}, {"id": "2", "city": "Rome", "country": "Italy"}]`)
+	overrides := write("overrides/10-paris.json", `[{"id": "1", "city": "Lyon", "country": "France"}]`)
+	write("overrides/20-oslo.json", `[{"id": "3", "city": "Oslo", "country": "Norway"}]`)
+	write("overrides/README.md", "not data")
+	broken := write("broken.json", "[\n  {\"id\": \"2\", \"city\": \"Rome\", \"country\": \"Italy\"},\n  {\"id\": \"1\", \"city\": \"Paris\"}\n]")
+	repeated := write("repeated.csv", "id,city,country\n1,Paris,France\n2,Rome,Italy\n1,Lyon,France\n")
+	unnamed := write("unnamed.yaml", "- id: \"1\"\n  city: Paris\n  country: France\n- city: Rome\n  country: Italy\n")
+	empty := write("empty.json", `[]`)
+
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantErr string
+	}{
+		{name: "single file", paths: []string{base}, want: []string{"1 Paris", "2 Rome"}},
+		{name: "directory in name order", paths: []string{base, filepath.Dir(overrides)}, want: []string{"1 Lyon", "2 Rome", "3 Oslo"}},
+		{name: "invalid json entry", paths: []string{base, broken}, wantErr: "broken.json:3 (1): missing country"},
+		{name: "repeated csv id", paths: []string{repeated}, wantErr: "repeated.csv:4: id 1 already used by " + repeated + ":2"},
+		{name: "invalid yaml entry", paths: []string{unnamed}, wantErr: "unnamed.yaml:4: missing id"},
+		{name: "empty dataset", paths: []string{empty}, wantErr: "no destinations"},
+		{name: "missing file", paths: []string{filepath.Join(dir, "missing.json")}, wantErr: "no such file"},
+		{name: "no data files", paths: []string{t.TempDir()}, wantErr: "no data files found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Load(test.paths...)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, d := range res.Destinations {
+				got = append(got, d.ID+" "+d.City)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("destinations = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func checkError(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case want == "" && err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		t.Errorf("expected an error containing %q", want)
+	case want != "" && !strings.Contains(err.Error(), want):
+		t.Errorf("error = %q, want it to contain %q", err, want)
+	}
+}
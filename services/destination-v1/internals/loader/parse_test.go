@@ -0,0 +1,151 @@
+package loader
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+func TestParse(t *testing.T) {
+	paris := data.Destination{
+		ID:          "1",
+		City:        "Paris",
+		Country:     "France",
+		Latitude:    48.8566,
+		Longitude:   2.3522,
+		Population:  2148000,
+		Description: "Capital of France",
+		Images:      []string{"a.jpg", "b.jpg"},
+	}
+	bare := data.Destination{ID: "2", City: "Rome", Country: "Italy", Images: []string{}}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []data.Destination
+		wantErr string
+	}{
+		{
+			name: "json",
+			file: "destinations.json",
+			content: `[{"id": "1", "city": "Paris", "country": "France", "latitude": 48.8566, "longitude": 2.3522,
+			"population": 2148000, "description": "Capital of France", "images": ["a.jpg", "b.jpg"]}]`,
+			want: []data.Destination{paris},
+		},
+		{
+			name:    "json syntax error has a line",
+			file:    "destinations.json",
+			content: "[\n{\"id\": \"1\",\n}]",
+			wantErr: "destinations.json:3:",
+		},
+		{
+			name:    "json type error has a line",
+			file:    "destinations.json",
+			content: "[\n{\"id\": \"1\",\n\"population\": \"many\"}]",
+			wantErr: "destinations.json:3:",
+		},
+		{
+			name: "csv",
+			file: "destinations.csv",
+			content: "id,city,country,latitude,longitude,population,description,images\n" +
+				`1,Paris,France,48.8566,2.3522,2148000,Capital of France,a.jpg|b.jpg` + "\n",
+			want: []data.Destination{paris},
+		},
+		{
+			name:    "csv columns in any order and optional",
+			file:    "destinations.CSV",
+			content: "Country, City, ID\nItaly, Rome, 2\n",
+			want:    []data.Destination{bare},
+		},
+		{
+			name:    "csv unknown column",
+			file:    "destinations.csv",
+			content: "id,city,country,altitude\n",
+			wantErr: `destinations.csv:1: unknown column "altitude"`,
+		},
+		{
+			name:    "csv duplicate column",
+			file:    "destinations.csv",
+			content: "id,city,country,city\n",
+			wantErr: `destinations.csv:1: duplicate column "city"`,
+		},
+		{
+			name:    "csv missing column",
+			file:    "destinations.csv",
+			content: "id,city\n",
+			wantErr: `destinations.csv:1: missing column "country"`,
+		},
+		{
+			name:    "csv invalid number",
+			file:    "destinations.csv",
+			content: "id,city,country,population\n2,Rome,Italy,4000000\n3,Oslo,Norway,lots\n",
+			wantErr: `destinations.csv:3: invalid population "lots"`,
+		},
+		{
+			name:    "csv wrong field count",
+			file:    "destinations.csv",
+			content: "id,city,country\n2,Rome\n",
+			wantErr: "destinations.csv:2:",
+		},
+		{
+			name:    "empty csv",
+			file:    "destinations.csv",
+			content: "",
+		},
+		{
+			name: "yaml",
+			file: "destinations.yaml",
+			content: `- id: "1"
+  city: Paris
+  country: France
+  latitude: 48.8566
+  longitude: 2.3522
+  population: 2148000
+  description: Capital of France
+  images: [a.jpg, b.jpg]
+`,
+			want: []data.Destination{paris},
+		},
+		{
+			name:    "yml without images",
+			file:    "destinations.yml",
+			content: "- {id: \"2\", city: Rome, country: Italy}\n",
+			want:    []data.Destination{bare},
+		},
+		{
+			name:    "yaml not a list",
+			file:    "destinations.yaml",
+			content: "id: 1\n",
+			wantErr: "destinations.yaml:1: expected a list of destinations",
+		},
+		{
+			name:    "yaml field error has the field's line",
+			file:    "destinations.yaml",
+			content: "- id: \"2\"\n  city: Rome\n  population: many\n",
+			wantErr: "destinations.yaml:3:",
+		},
+		{
+			name:    "unsupported format",
+			file:    "destinations.xml",
+			wantErr: "destinations.xml: unsupported format",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.file, []byte(test.content))
+			checkError(t, err, test.wantErr)
+			if err != nil || test.wantErr != "" {
+				return
+			}
+			if len(got) == 0 && len(test.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
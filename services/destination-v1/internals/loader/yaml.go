@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseYAML decodes a YAML sequence of destinations.
+func ParseYAML(name string, b []byte) ([]data.Destination, error) {
+	destinations, _, err := parseYAML(name, b)
+	return destinations, err
+}
+
+func parseYAML(name string, b []byte) ([]data.Destination, []int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	res := make([]data.Destination, 0)
+	lines := make([]int, 0)
+	if len(doc.Content) == 0 {
+		return res, lines, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.SequenceNode {
+		return nil, nil, fmt.Errorf("%s:%d: expected a list of destinations", name, root.Line)
+	}
+
+	for _, item := range root.Content {
+		var destination data.Destination
+		if err := item.Decode(&destination); err != nil {
+			return nil, nil, yamlError(name, item.Line, err)
+		}
+		if destination.Images == nil {
+			destination.Images = make([]string, 0)
+		}
+		res = append(res, destination)
+		lines = append(lines, item.Line)
+	}
+	return res, lines, nil
+}
+
+// yamlError reports the line of the first offending field when yaml knows it,
+// and the line of the destination otherwise.
+func yamlError(name string, line int, err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) || len(typeErr.Errors) == 0 {
+		return fmt.Errorf("%s:%d: %w", name, line, err)
+	}
+
+	msg := typeErr.Errors[0]
+	var fieldLine int
+	if _, scanErr := fmt.Sscanf(msg, "line %d:", &fieldLine); scanErr == nil {
+		line = fieldLine
+		msg = strings.TrimSpace(msg[strings.Index(msg, ":")+1:])
+	}
+	return fmt.Errorf("%s:%d: %s", name, line, msg)
+}
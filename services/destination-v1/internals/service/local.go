@@ -1,6 +1,10 @@
 package service
 
-import "github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+import (
+	"context"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
 
 type LocalDB struct {
 	destination []data.Destination
@@ -10,29 +14,29 @@ func NewLocalDB(destination []data.Destination) LocalDB {
 	return LocalDB{destination: destination}
 }
 
-func (l LocalDB) ByCityCountry(city, country string) data.Destination {
+func (l LocalDB) ByCityCountry(_ context.Context, city, country string) (data.Destination, error) {
 	var res data.Destination
 	for _, destination := range l.destination {
-		if normalize(destination.City) == city && normalize(destination.Country) == country {
+		if Normalize(destination.City) == city && Normalize(destination.Country) == country {
 			res = destination
 			break
 		}
 	}
-	return res
+	return res, nil
 }
 
-func (l LocalDB) ByCountry(country string) []data.Destination {
+func (l LocalDB) ByCountry(_ context.Context, country string) ([]data.Destination, error) {
 	res := make([]data.Destination, 0)
 
 	for _, destination := range l.destination {
-		if normalize(destination.Country) == country {
+		if Normalize(destination.Country) == country {
 			res = append(res, destination)
 		}
 	}
-	return res
+	return res, nil
 }
 
-func (l LocalDB) All() []data.DestinationList {
+func (l LocalDB) All(_ context.Context) ([]data.DestinationList, error) {
 	res := make([]data.DestinationList, len(l.destination))
 	for i, destination := range l.destination {
 		res[i] = data.DestinationList{
@@ -40,5 +44,5 @@ func (l LocalDB) All() []data.DestinationList {
 			City:    destination.City,
 		}
 	}
-	return res
+	return res, nil
 }
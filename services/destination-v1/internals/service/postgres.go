@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const postgresColumns = "id, city, country, latitude, longitude, population, description, images"
+
+// postgresKey normalizes a column in SQL the way Normalize does in Go. The
+// lookups are served by destination_location_key_idx, from destination-v2's
+// migration 0011, which only matches queries on this exact expression and on
+// deleted_at IS NULL: change them together.
+func postgresKey(column string) string {
+	return "replace(lower(" + column + "), ' ', '-')"
+}
+
+// PostgresDB is a data.DataProvider that reads the destination table managed
+// by destination-v2, so that the v1 API can be served from the same data.
+// Destinations that v2 has soft-deleted are left out.
+type PostgresDB struct {
+	pool *pgxpool.Pool
+}
+
+// ConnectPostgres connects to the database described by connString.
+func ConnectPostgres(ctx context.Context, connString string) (*PostgresDB, error) {
+	pool, err := pgxpool.Connect(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresDB{pool: pool}, nil
+}
+
+func (p *PostgresDB) Close() {
+	p.pool.Close()
+}
+
+// Count returns the number of destinations being served.
+func (p *PostgresDB) Count(ctx context.Context) (int, error) {
+	var count int
+	err := p.pool.QueryRow(ctx, "SELECT count(*) FROM destination WHERE deleted_at IS NULL").Scan(&count)
+	return count, err
+}
+
+func (p *PostgresDB) ByCityCountry(ctx context.Context, city, country string) (data.Destination, error) {
+	destinations, err := p.query(ctx,
+		"SELECT "+postgresColumns+" FROM destination WHERE deleted_at IS NULL AND "+
+			postgresKey("country")+" = $1 AND "+postgresKey("city")+" = $2 ORDER BY id LIMIT 1",
+		country, city)
+	if err != nil || len(destinations) == 0 {
+		return data.Destination{}, err
+	}
+	return destinations[0], nil
+}
+
+func (p *PostgresDB) ByCountry(ctx context.Context, country string) ([]data.Destination, error) {
+	return p.query(ctx,
+		"SELECT "+postgresColumns+" FROM destination WHERE deleted_at IS NULL AND "+
+			postgresKey("country")+" = $1 ORDER BY city, id",
+		country)
+}
+
+func (p *PostgresDB) All(ctx context.Context) ([]data.DestinationList, error) {
+	rows, err := p.pool.Query(ctx, "SELECT country, city FROM destination WHERE deleted_at IS NULL ORDER BY country, city")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]data.DestinationList, 0)
+	for rows.Next() {
+		var location data.DestinationList
+		if err := rows.Scan(&location.Country, &location.City); err != nil {
+			return nil, err
+		}
+		res = append(res, location)
+	}
+	return res, rows.Err()
+}
+
+func (p *PostgresDB) query(ctx context.Context, query string, args ...interface{}) ([]data.Destination, error) {
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]data.Destination, 0)
+	for rows.Next() {
+		var destination data.Destination
+		err := rows.Scan(
+			&destination.ID, &destination.City, &destination.Country,
+			&destination.Latitude, &destination.Longitude, &destination.Population,
+			&destination.Description, &destination.Images,
+		)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, destination)
+	}
+	return res, rows.Err()
+}
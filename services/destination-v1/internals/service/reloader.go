@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+// LoadFunc produces a fresh, validated dataset.
+type LoadFunc func() ([]data.Destination, error)
+
+// Status describes the dataset currently being served and the outcome of the
+// most recent reload attempt.
+type Status struct {
+	Paths       []string  `json:"paths"`
+	Count       int       `json:"count"`
+	LoadedAt    time.Time `json:"loadedAt"`
+	LastAttempt time.Time `json:"lastAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// Reloader is a data.DataProvider that serves a LocalDB and swaps it for a
+// new one whenever the underlying files change. A dataset that fails to load
+// never replaces the one being served.
+type Reloader struct {
+	paths []string
+	load  LoadFunc
+
+	provider atomic.Value // data.DataProvider
+	status   atomic.Value // Status
+
+	mu          sync.Mutex
+	fingerprint string
+}
+
+// NewReloader performs the initial load and fails if it doesn't succeed.
+func NewReloader(load LoadFunc, paths ...string) (*Reloader, error) {
+	r := &Reloader{paths: paths, load: load}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) ByCityCountry(ctx context.Context, city, country string) (data.Destination, error) {
+	return r.current().ByCityCountry(ctx, city, country)
+}
+
+func (r *Reloader) ByCountry(ctx context.Context, country string) ([]data.Destination, error) {
+	return r.current().ByCountry(ctx, country)
+}
+
+func (r *Reloader) All(ctx context.Context) ([]data.DestinationList, error) {
+	return r.current().All(ctx)
+}
+
+func (r *Reloader) current() data.DataProvider {
+	return r.provider.Load().(data.DataProvider)
+}
+
+// Status returns a snapshot of the reload state.
+func (r *Reloader) Status() Status {
+	return r.status.Load().(Status)
+}
+
+// Reload loads the dataset and, if it is valid, starts serving it.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Remember what was attempted even if it fails, so a broken file is only
+	// retried once it changes again.
+	r.fingerprint = r.currentFingerprint()
+	status, _ := r.status.Load().(Status)
+	status.Paths = r.paths
+	status.LastAttempt = time.Now()
+
+	destinations, err := r.load()
+	if err != nil {
+		status.LastError = err.Error()
+		r.status.Store(status)
+		return err
+	}
+
+	r.provider.Store(data.DataProvider(NewLocalDB(destinations)))
+	status.Count = len(destinations)
+	status.LoadedAt = status.LastAttempt
+	status.LastError = ""
+	r.status.Store(status)
+	return nil
+}
+
+// Watch polls the data files every interval and reloads them when they
+// change. Polling is used rather than inotify because volumes mounted from
+// ConfigMaps are updated through symlink swaps that file watchers miss.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if len(r.paths) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("reload failed, keeping previous data: %v", err)
+				continue
+			}
+			log.Printf("reloaded %d destinations from %v", r.Status().Count, r.paths)
+		}
+	}
+}
+
+func (r *Reloader) changed() bool {
+	fingerprint := r.currentFingerprint()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fingerprint != r.fingerprint
+}
+
+// currentFingerprint summarises the size and modification time of every
+// watched path, and of the files inside watched directories, so that any
+// edit, replacement or removal changes it.
+func (r *Reloader) currentFingerprint() string {
+	fingerprint := ""
+	for _, path := range r.paths {
+		fingerprint += stamp(path)
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			fingerprint += stamp(filepath.Join(path, entry.Name()))
+		}
+	}
+	return fingerprint
+}
+
+func stamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("%s:missing;", path)
+	}
+	return fmt.Sprintf("%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+}
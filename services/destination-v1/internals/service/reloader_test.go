@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+func TestReloaderKeepsServingAfterFailedReload(t *testing.T) {
+	tests := []struct {
+		name      string
+		loads     []error
+		wantCount int
+		wantError string
+	}{
+		{name: "successful reload", loads: []error{nil, nil}, wantCount: 2},
+		{name: "failed reload", loads: []error{nil, errors.New("broken file")}, wantCount: 1, wantError: "broken file"},
+		{name: "recovers", loads: []error{nil, errors.New("broken file"), nil}, wantCount: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			load := func() ([]data.Destination, error) {
+				err := test.loads[calls]
+				calls++
+				if err != nil {
+					return nil, err
+				}
+				destinations := make([]data.Destination, calls)
+				for i := range destinations {
+					destinations[i] = data.Destination{City: "City", Country: "Country"}
+				}
+				return destinations, nil
+			}
+
+			r, err := NewReloader(load)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range test.loads[1:] {
+				r.Reload()
+			}
+
+			all, err := r.All(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != test.wantCount {
+				t.Errorf("serving %d destinations, want %d", len(all), test.wantCount)
+			}
+			if status := r.Status(); status.Count != test.wantCount || status.LastError != test.wantError {
+				t.Errorf("status = %+v, want count %d and error %q", status, test.wantCount, test.wantError)
+			}
+		})
+	}
+}
+
+func TestNewReloaderFailsOnInitialLoad(t *testing.T) {
+	load := func() ([]data.Destination, error) { return nil, errors.New("missing file") }
+	if _, err := NewReloader(load); err == nil {
+		t.Error("NewReloader succeeded with a failing load")
+	}
+}
+
+func TestReloaderChanged(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "destinations.json")
+	if err := os.WriteFile(file, []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T)
+		want   bool
+	}{
+		{name: "unchanged", change: func(t *testing.T) {}, want: false},
+		{name: "rewritten", change: func(t *testing.T) {
+			if err := os.WriteFile(file, []byte("[ ]"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, want: true},
+		{name: "touched", change: func(t *testing.T) {
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(file, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}, want: true},
+		{name: "file added to directory", change: func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(dir, "more.json"), []byte("[]"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, want: true},
+		{name: "removed", change: func(t *testing.T) {
+			if err := os.Remove(file); err != nil {
+				t.Fatal(err)
+			}
+		}, want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			load := func() ([]data.Destination, error) { return nil, nil }
+			r, err := NewReloader(load, file, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.change(t)
+			if got := r.changed(); got != test.want {
+				t.Errorf("changed() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
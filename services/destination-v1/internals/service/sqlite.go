@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+
+	// Registers the pure Go "sqlite" driver, so that static builds work.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema stores every destination with its normalized city and country
+// next to the original names. The index serves lookups by country as well as
+// by country and city. Images are kept as a JSON array.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS destination (
+	id          TEXT PRIMARY KEY,
+	city        TEXT NOT NULL,
+	country     TEXT NOT NULL,
+	city_key    TEXT NOT NULL,
+	country_key TEXT NOT NULL,
+	latitude    REAL NOT NULL,
+	longitude   REAL NOT NULL,
+	population  INTEGER NOT NULL,
+	description TEXT NOT NULL,
+	images      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS destination_country_city ON destination (country_key, city_key);
+`
+
+const sqliteColumns = "id, city, country, latitude, longitude, population, description, images"
+
+// SQLiteDB is a data.DataProvider that reads an SQLite file, for deployments
+// that need persistent storage without a database server. Destinations are
+// returned in the order they were imported, like LocalDB.
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the SQLite file at path, creating it and its schema when
+// they don't exist.
+func OpenSQLite(path string) (*SQLiteDB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &SQLiteDB{db: db}, nil
+}
+
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+// Import replaces the stored destinations with destinations, in a single
+// transaction so that readers never see a partial catalogue.
+func (s *SQLiteDB) Import(ctx context.Context, destinations []data.Destination) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM destination"); err != nil {
+		return err
+	}
+	insert, err := tx.PrepareContext(ctx,
+		"INSERT INTO destination ("+sqliteColumns+", city_key, country_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for _, destination := range destinations {
+		images, err := json.Marshal(destination.Images)
+		if err != nil {
+			return err
+		}
+		_, err = insert.ExecContext(ctx,
+			destination.ID, destination.City, destination.Country,
+			destination.Latitude, destination.Longitude, destination.Population,
+			destination.Description, string(images),
+			Normalize(destination.City), Normalize(destination.Country),
+		)
+		if err != nil {
+			return fmt.Errorf("destination %s: %w", destination.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Count returns the number of stored destinations.
+func (s *SQLiteDB) Count(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM destination").Scan(&count)
+	return count, err
+}
+
+func (s *SQLiteDB) ByCityCountry(ctx context.Context, city, country string) (data.Destination, error) {
+	destinations, err := s.query(ctx,
+		"SELECT "+sqliteColumns+" FROM destination WHERE country_key = ? AND city_key = ? ORDER BY rowid LIMIT 1",
+		country, city)
+	if err != nil || len(destinations) == 0 {
+		return data.Destination{}, err
+	}
+	return destinations[0], nil
+}
+
+func (s *SQLiteDB) ByCountry(ctx context.Context, country string) ([]data.Destination, error) {
+	return s.query(ctx,
+		"SELECT "+sqliteColumns+" FROM destination WHERE country_key = ? ORDER BY rowid",
+		country)
+}
+
+func (s *SQLiteDB) All(ctx context.Context) ([]data.DestinationList, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT country, city FROM destination ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]data.DestinationList, 0)
+	for rows.Next() {
+		var location data.DestinationList
+		if err := rows.Scan(&location.Country, &location.City); err != nil {
+			return nil, err
+		}
+		res = append(res, location)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLiteDB) query(ctx context.Context, query string, args ...interface{}) ([]data.Destination, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]data.Destination, 0)
+	for rows.Next() {
+		var destination data.Destination
+		var images string
+		err := rows.Scan(
+			&destination.ID, &destination.City, &destination.Country,
+			&destination.Latitude, &destination.Longitude, &destination.Population,
+			&destination.Description, &images,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(images), &destination.Images); err != nil {
+			return nil, fmt.Errorf("destination %s: images: %w", destination.ID, err)
+		}
+		res = append(res, destination)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bee-travels/bee-travels-go/services/destination-v1/internals/data"
+)
+
+func TestSQLiteDB(t *testing.T) {
+	newYork := data.Destination{ID: "1", City: "New York", Country: "United States", Latitude: 40.7, Longitude: -74, Population: 8000000, Description: "Big", Images: []string{"a.jpg", "b.jpg"}}
+	boston := data.Destination{ID: "2", City: "Boston", Country: "United States", Images: []string{}}
+	paris := data.Destination{ID: "3", City: "Paris", Country: "France", Images: []string{"c.jpg"}}
+
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "destinations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Import(ctx, []data.Destination{newYork, boston, paris}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query func() (interface{}, error)
+		want  interface{}
+	}{
+		{
+			name:  "count",
+			query: func() (interface{}, error) { return db.Count(ctx) },
+			want:  3,
+		},
+		{
+			name:  "by city and country",
+			query: func() (interface{}, error) { return db.ByCityCountry(ctx, "new-york", "united-states") },
+			want:  newYork,
+		},
+		{
+			name:  "unknown city",
+			query: func() (interface{}, error) { return db.ByCityCountry(ctx, "chicago", "united-states") },
+			want:  data.Destination{},
+		},
+		{
+			name:  "by country in import order",
+			query: func() (interface{}, error) { return db.ByCountry(ctx, "united-states") },
+			want:  []data.Destination{newYork, boston},
+		},
+		{
+			name:  "unknown country",
+			query: func() (interface{}, error) { return db.ByCountry(ctx, "spain") },
+			want:  []data.Destination{},
+		},
+		{
+			name:  "all in import order",
+			query: func() (interface{}, error) { return db.All(ctx) },
+			want: []data.DestinationList{
+				{Country: "United States", City: "New York"},
+				{Country: "United States", City: "Boston"},
+				{Country: "France", City: "Paris"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.query()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteDBImportReplaces(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "destinations.db")
+	db, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := []data.Destination{{ID: "1", City: "Paris", Country: "France", Images: []string{}}, {ID: "2", City: "Rome", Country: "Italy", Images: []string{}}}
+	if err := db.Import(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Import(ctx, first[1:]); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The file keeps the last import.
+	db, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count, err := db.Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("Count() = %d after importing 1 destination, want 1", count)
+	}
+	if d, _ := db.ByCityCountry(ctx, "paris", "france"); d.ID != "" {
+		t.Errorf("Paris is still served after being left out of the import")
+	}
+}
+
+func TestSQLiteDBImportIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "destinations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Import(ctx, []data.Destination{{ID: "1", City: "Paris", Country: "France", Images: []string{}}}); err != nil {
+		t.Fatal(err)
+	}
+	duplicates := []data.Destination{{ID: "2", City: "Rome", Country: "Italy"}, {ID: "2", City: "Milan", Country: "Italy"}}
+	if err := db.Import(ctx, duplicates); err == nil {
+		t.Fatal("Import() of a duplicate id succeeded")
+	}
+
+	if d, err := db.ByCityCountry(ctx, "paris", "france"); err != nil || d.ID != "1" {
+		t.Errorf("ByCityCountry() = %+v, %v after a failed import, want the previous catalogue", d, err)
+	}
+}
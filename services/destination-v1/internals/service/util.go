@@ -2,7 +2,8 @@ package service
 
 import "strings"
 
-func normalize(location string) string {
+// Normalize turns a city or country name into the form used in URL paths.
+func Normalize(location string) string {
 	split := strings.Split(strings.ToLower(location), " ")
 	return strings.Join(split, "-")
 }
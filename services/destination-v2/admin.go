@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// isAdmin reports whether the request carries the ADMIN_TOKEN as a bearer
+// token. Nobody is an admin when ADMIN_TOKEN is unset.
+func isAdmin(ctx server.RequestContext) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		return false
+	}
+	header := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
+
+// principal names the identity a request authenticated as, which is empty
+// for anonymous requests.
+func principal(ctx server.RequestContext) string {
+	if isAdmin(ctx) {
+		return "admin"
+	}
+	return ""
+}
+
+// adminOnly refuses requests that don't come from an admin. The others get
+// a bulkhead of their own, so that a bulk operation can't starve the public
+// API of connections.
+func adminOnly(handler server.RequestHandler) server.RequestHandler {
+	return requireAdmin(func(ctx server.RequestContext) {
+		ctx.ResetRequest(ctx.Request().WithContext(database.WithRouteClass(ctx.Request().Context(), database.AdminRoutes)))
+		handler(ctx)
+	})
+}
+
+// requireAdmin refuses requests that don't come from an admin, like
+// adminOnly, but leaves the others in the bulkhead of their method. It
+// guards the changes to single destinations, which are ordinary writes.
+func requireAdmin(handler server.RequestHandler) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		if !isAdmin(ctx) {
+			server.Response(ctx, http.StatusForbidden, Error{
+				Error: "admin token required",
+			})
+			return
+		}
+		handler(ctx)
+	}
+}
+
+// databaseStats reports the state of the connection pool, to compare the
+// health check strategies, of the circuit breaker and bulkheads, of the
+// query cache and of query coalescing.
+func databaseStats(pool database.Pool) server.RequestHandler {
+	type stats struct {
+		pgxpool.Stats
+		Guard      *database.GuardStats    `json:"guard,omitempty"`
+		Cache      *database.CacheStats    `json:"cache,omitempty"`
+		Coalescing *database.CoalesceStats `json:"coalescing,omitempty"`
+	}
+
+	return func(ctx server.RequestContext) {
+		var response stats
+		underlying := pool
+		if cached, ok := underlying.(*database.CachedPool); ok {
+			cache := cached.Cache().Stats()
+			response.Cache = &cache
+			underlying = cached.Pool()
+		}
+		if coalescing, ok := underlying.(*database.CoalescingPool); ok {
+			coalesce := coalescing.Stats()
+			response.Coalescing = &coalesce
+			underlying = coalescing.Pool()
+		}
+		if guarded, ok := underlying.(*database.GuardedPool); ok {
+			guard := guarded.Stats()
+			response.Guard = &guard
+			underlying = guarded.Pool()
+		}
+		p, ok := underlying.(*pgxpool.Pool)
+		if !ok {
+			server.Response(ctx, http.StatusNotImplemented, Error{
+				Error: "no statistics for this pool",
+			})
+			return
+		}
+		response.Stats = p.Stats()
+		server.Response(ctx, http.StatusOK, response)
+	}
+}
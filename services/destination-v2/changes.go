@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	changesChannel = "destination_changes"
+	// changesBuffer is how far a client may fall behind before it is
+	// disconnected; it then resumes from its Last-Event-ID.
+	changesBuffer    = 256
+	changesHeartbeat = 15 * time.Second
+	changesPageSize  = 1000
+	// changesPollInterval bounds how long a change waits for the
+	// transactions before it to end when none of them announces it.
+	changesPollInterval = 5 * time.Second
+)
+
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeDelete = "delete"
+)
+
+// ChangeEvent is a destination change as sent on the feed. Its ID is the
+// history entry's; its position, which clients send back in Last-Event-ID to
+// resume, orders it on the feed.
+type ChangeEvent struct {
+	ID            int64          `json:"id"`
+	Type          string         `json:"type"`
+	DestinationID string         `json:"destinationId"`
+	Actor         string         `json:"actor"`
+	ChangedAt     time.Time      `json:"changedAt"`
+	Destination   *Destination   `json:"destination"`
+	Position      changePosition `json:"-"`
+}
+
+// changePosition orders history entries by the transaction that wrote them,
+// then by id. History ids are taken before transactions commit, so an entry
+// can become visible after one with a greater id; the feed only reads entries
+// of transactions older than every one in progress, so that nothing can
+// appear before a position once it has been read.
+type changePosition struct {
+	TransactionID int64
+	HistoryID     int64
+}
+
+func positionOf(entry HistoryEntry) changePosition {
+	return changePosition{TransactionID: entry.TransactionID, HistoryID: entry.ID}
+}
+
+// String formats p as "<transaction id>.<history id>".
+func (p changePosition) String() string {
+	return fmt.Sprintf("%d.%d", p.TransactionID, p.HistoryID)
+}
+
+func (p changePosition) before(other changePosition) bool {
+	if p.TransactionID != other.TransactionID {
+		return p.TransactionID < other.TransactionID
+	}
+	return p.HistoryID < other.HistoryID
+}
+
+func parseChangePosition(s string) (changePosition, error) {
+	var p changePosition
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return p, fmt.Errorf("invalid position %q", s)
+	}
+	var err error
+	if p.TransactionID, err = strconv.ParseInt(parts[0], 10, 64); err != nil || p.TransactionID < 0 {
+		return p, fmt.Errorf("invalid position %q", s)
+	}
+	if p.HistoryID, err = strconv.ParseInt(parts[1], 10, 64); err != nil || p.HistoryID < 0 {
+		return p, fmt.Errorf("invalid position %q", s)
+	}
+	return p, nil
+}
+
+// changeFeed fans the changes announced by the history trigger out to the
+// connected clients, in the order of their positions.
+type changeFeed struct {
+	pool        database.Pool
+	mu          sync.Mutex
+	subscribers map[chan ChangeEvent]struct{}
+	position    changePosition
+	started     bool
+	// reading serializes the reads that move position forward.
+	reading sync.Mutex
+}
+
+func newChangeFeed(pool database.Pool) *changeFeed {
+	return &changeFeed{
+		pool:        pool,
+		subscribers: make(map[chan ChangeEvent]struct{}),
+	}
+}
+
+// run listens for changes until ctx is done. Notifications only wake the
+// feed up: it reads what follows its position, and polls as well for the
+// entries held back by a transaction that ended without announcing anything.
+func (f *changeFeed) run(ctx context.Context) {
+	listener, err := database.NewListener(changesChannel)
+	if err != nil {
+		fmt.Printf("change feed disabled: %v\n", err)
+		return
+	}
+
+	go func() {
+		poll := time.NewTicker(changesPollInterval)
+		defer poll.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-poll.C:
+				f.readChanges()
+			}
+		}
+	}()
+
+	listener.Listen(ctx, f.catchUp, f.notify)
+}
+
+// catchUp publishes what was recorded while the listener was disconnected.
+// On the first connection, the feed starts after the last entry.
+func (f *changeFeed) catchUp(ctx context.Context) error {
+	// Changes made while disconnected may be cached.
+	database.Invalidate(f.pool, "destination")
+
+	f.reading.Lock()
+	defer f.reading.Unlock()
+
+	ctx = pgxpool.WithPrimary(ctx)
+	f.mu.Lock()
+	started := f.started
+	f.mu.Unlock()
+
+	if !started {
+		position, err := queryLatestPosition(f.pool, ctx)
+		if err != nil {
+			return err
+		}
+		f.mu.Lock()
+		f.position, f.started = position, true
+		f.mu.Unlock()
+		return nil
+	}
+	return f.readAfterPosition(ctx)
+}
+
+func (f *changeFeed) notify(notification database.Notification) {
+	// The change may have been made by another instance, whose writes the
+	// cache didn't see.
+	database.Invalidate(f.pool, "destination")
+	f.readChanges()
+}
+
+// readChanges publishes the entries that follow the feed's position, once
+// the feed has started.
+func (f *changeFeed) readChanges() {
+	f.reading.Lock()
+	defer f.reading.Unlock()
+
+	f.mu.Lock()
+	started := f.started
+	f.mu.Unlock()
+	if !started {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(pgxpool.WithPrimary(context.Background()), 20*time.Second)
+	defer cancel()
+	if err := f.readAfterPosition(ctx); err != nil {
+		fmt.Printf("couldn't read the destination history: %v\n", err)
+	}
+}
+
+// readAfterPosition publishes the entries after the feed's position, page by
+// page. The caller holds f.reading.
+func (f *changeFeed) readAfterPosition(ctx context.Context) error {
+	f.mu.Lock()
+	position := f.position
+	f.mu.Unlock()
+
+	for {
+		entries, err := queryHistoryAfter(f.pool, ctx, position, changesPageSize)
+		if err != nil {
+			return err
+		}
+		f.publish(entries)
+		if len(entries) < changesPageSize {
+			return nil
+		}
+		position = positionOf(entries[len(entries)-1])
+	}
+}
+
+// publish sends entries, which follow the feed's position in order, to every
+// subscriber. A subscriber whose buffer is full is dropped rather than
+// allowed to hold the others up.
+func (f *changeFeed) publish(entries []HistoryEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, entry := range entries {
+		position := positionOf(entry)
+		if !f.position.before(position) {
+			continue
+		}
+		f.position = position
+
+		event, err := changeEventFrom(entry)
+		if err != nil {
+			fmt.Printf("couldn't decode history entry %d: %v\n", entry.ID, err)
+			continue
+		}
+		for subscriber := range f.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				delete(f.subscribers, subscriber)
+				close(subscriber)
+			}
+		}
+	}
+}
+
+// subscribe returns a channel of the changes published from now on, which is
+// closed if the subscriber falls behind, and a function to unsubscribe.
+func (f *changeFeed) subscribe() (<-chan ChangeEvent, func()) {
+	events := make(chan ChangeEvent, changesBuffer)
+
+	f.mu.Lock()
+	f.subscribers[events] = struct{}{}
+	f.mu.Unlock()
+
+	return events, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subscribers[events]; ok {
+			delete(f.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// streamChanges serves the feed as Server-Sent Events. A client that sends
+// Last-Event-ID, or the last_event_id query parameter, first receives the
+// changes it missed.
+func streamChanges(pool database.Pool, feed *changeFeed) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		resumeFrom := ctx.GetHeader("Last-Event-ID")
+		if resumeFrom == "" {
+			resumeFrom = ctx.URLParam("last_event_id")
+		}
+		var lastPosition changePosition
+		if resumeFrom != "" {
+			var err error
+			if lastPosition, err = parseChangePosition(resumeFrom); err != nil {
+				server.Response(ctx, http.StatusBadRequest, Error{
+					Error: fmt.Sprintf("invalid Last-Event-ID %q", resumeFrom),
+				})
+				return
+			}
+		}
+
+		// Subscribing before replaying means nothing falls between the two:
+		// the feed publishes in position order, so whatever the replay can't
+		// read yet is still to come on the feed, and events that were also
+		// replayed are skipped by position. The replay reads the primary,
+		// which the feed reads too, so that a lagging replica can't leave a
+		// gap.
+		events, unsubscribe := feed.subscribe()
+		defer unsubscribe()
+		readCtx := pgxpool.WithPrimary(ctx.Request().Context())
+
+		var missed []HistoryEntry
+		if resumeFrom != "" {
+			var err error
+			missed, err = queryHistoryAfter(pool, readCtx, lastPosition, changesPageSize)
+			if err != nil {
+				requestFailed(ctx, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("Connection", "keep-alive")
+		ctx.Header("X-Accel-Buffering", "no")
+		ctx.StatusCode(http.StatusOK)
+		ctx.ResponseWriter().Flush()
+
+		for len(missed) > 0 {
+			for _, entry := range missed {
+				event, err := changeEventFrom(entry)
+				if err != nil {
+					continue
+				}
+				if err := writeChangeEvent(ctx, event); err != nil {
+					return
+				}
+			}
+			lastPosition = positionOf(missed[len(missed)-1])
+			if len(missed) < changesPageSize {
+				break
+			}
+
+			var err error
+			if missed, err = queryHistoryAfter(pool, readCtx, lastPosition, changesPageSize); err != nil {
+				return
+			}
+		}
+
+		heartbeat := time.NewTicker(changesHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-ctx.Request().Context().Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if !lastPosition.before(event.Position) {
+					continue
+				}
+				if err := writeChangeEvent(ctx, event); err != nil {
+					return
+				}
+				lastPosition = event.Position
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(ctx.ResponseWriter(), ": heartbeat\n\n"); err != nil {
+					return
+				}
+				ctx.ResponseWriter().Flush()
+			}
+		}
+	}
+}
+
+func writeChangeEvent(ctx server.RequestContext, event ChangeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(ctx.ResponseWriter(), "id: %s\nevent: %s\ndata: %s\n\n", event.Position, event.Type, data); err != nil {
+		return err
+	}
+	ctx.ResponseWriter().Flush()
+	return nil
+}
+
+// changeEventFrom maps a history entry to an event. Soft deletes and
+// restores are updates of the row, but deletes and creates to clients.
+func changeEventFrom(entry HistoryEntry) (ChangeEvent, error) {
+	before, err := historyDestination(entry.Before)
+	if err != nil {
+		return ChangeEvent{}, err
+	}
+	after, err := historyDestination(entry.After)
+	if err != nil {
+		return ChangeEvent{}, err
+	}
+
+	event := ChangeEvent{
+		ID:            entry.ID,
+		Position:      positionOf(entry),
+		DestinationID: entry.DestinationID,
+		Actor:         entry.Actor,
+		ChangedAt:     entry.ChangedAt,
+		Destination:   after,
+	}
+	switch {
+	case before == nil:
+		event.Type = changeCreate
+	case after == nil:
+		event.Type = changeDelete
+		event.Destination = before
+	case after.DeletedAt != nil && before.DeletedAt == nil:
+		event.Type = changeDelete
+	case after.DeletedAt == nil && before.DeletedAt != nil:
+		event.Type = changeCreate
+	default:
+		event.Type = changeUpdate
+	}
+	return event, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseChangePosition(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    changePosition
+		wantErr bool
+	}{
+		{value: "812.42", want: changePosition{TransactionID: 812, HistoryID: 42}},
+		{value: "0.0", want: changePosition{}},
+		{value: "42", wantErr: true},
+		{value: "1.2.3", wantErr: true},
+		{value: "a.1", wantErr: true},
+		{value: "1.b", wantErr: true},
+		{value: "-1.1", wantErr: true},
+		{value: "1.-1", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseChangePosition(test.value)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseChangePosition(%q) error = %v, want error: %v", test.value, err, test.wantErr)
+			}
+			if !test.wantErr && got != test.want {
+				t.Errorf("parseChangePosition(%q) = %+v, want %+v", test.value, got, test.want)
+			}
+			if !test.wantErr && got.String() != test.value {
+				t.Errorf("String() = %q, want %q", got.String(), test.value)
+			}
+		})
+	}
+}
+
+func TestChangePositionBefore(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b changePosition
+		want bool
+	}{
+		{name: "earlier transaction with a greater id", a: changePosition{TransactionID: 10, HistoryID: 9}, b: changePosition{TransactionID: 11, HistoryID: 5}, want: true},
+		{name: "later transaction with a smaller id", a: changePosition{TransactionID: 11, HistoryID: 5}, b: changePosition{TransactionID: 10, HistoryID: 9}},
+		{name: "same transaction", a: changePosition{TransactionID: 10, HistoryID: 1}, b: changePosition{TransactionID: 10, HistoryID: 2}, want: true},
+		{name: "same position", a: changePosition{TransactionID: 10, HistoryID: 1}, b: changePosition{TransactionID: 10, HistoryID: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.a.before(test.b); got != test.want {
+				t.Errorf("%v.before(%v) = %v, want %v", test.a, test.b, got, test.want)
+			}
+		})
+	}
+}
+
+func historyEntry(transactionID, id int64, before, after string) HistoryEntry {
+	entry := HistoryEntry{ID: id, TransactionID: transactionID, DestinationID: "1", ChangedAt: time.Now()}
+	if before != "" {
+		entry.Before = json.RawMessage(before)
+	}
+	if after != "" {
+		entry.After = json.RawMessage(after)
+	}
+	return entry
+}
+
+func TestChangeFeedPublish(t *testing.T) {
+	const row = `{"id":"1","city":"Paris"}`
+
+	tests := []struct {
+		name    string
+		start   changePosition
+		entries []HistoryEntry
+		want    []changePosition
+	}{
+		{
+			name:    "in order",
+			entries: []HistoryEntry{historyEntry(10, 2, "", row), historyEntry(11, 1, row, row)},
+			want:    []changePosition{{10, 2}, {11, 1}},
+		},
+		{
+			name:    "already published",
+			start:   changePosition{TransactionID: 10, HistoryID: 2},
+			entries: []HistoryEntry{historyEntry(10, 1, "", row), historyEntry(10, 2, "", row), historyEntry(10, 3, "", row)},
+			want:    []changePosition{{10, 3}},
+		},
+		{
+			name:    "undecodable entry",
+			entries: []HistoryEntry{historyEntry(10, 1, "", `{"id":`), historyEntry(10, 2, "", row)},
+			want:    []changePosition{{10, 2}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed := newChangeFeed(nil)
+			feed.position = test.start
+			events, unsubscribe := feed.subscribe()
+			defer unsubscribe()
+
+			feed.publish(test.entries)
+
+			for _, want := range test.want {
+				select {
+				case event := <-events:
+					if event.Position != want {
+						t.Errorf("published %v, want %v", event.Position, want)
+					}
+				default:
+					t.Fatalf("nothing published, want %v", want)
+				}
+			}
+			select {
+			case event := <-events:
+				t.Errorf("published %v, want nothing more", event.Position)
+			default:
+			}
+
+			last := test.start
+			if len(test.entries) > 0 {
+				last = positionOf(test.entries[len(test.entries)-1])
+			}
+			if feed.position != last {
+				t.Errorf("feed at %v, want %v", feed.position, last)
+			}
+		})
+	}
+}
+
+func TestChangeFeedDropsSlowSubscribers(t *testing.T) {
+	feed := newChangeFeed(nil)
+	slow, _ := feed.subscribe()
+	entries := make([]HistoryEntry, changesBuffer+1)
+	for i := range entries {
+		entries[i] = historyEntry(10, int64(i+1), "", `{"id":"1"}`)
+	}
+
+	feed.publish(entries)
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != changesBuffer {
+		t.Errorf("received %d events before being dropped, want %d", received, changesBuffer)
+	}
+	if len(feed.subscribers) != 0 {
+		t.Errorf("%d subscribers left, want 0", len(feed.subscribers))
+	}
+}
+
+func TestChangeEventFrom(t *testing.T) {
+	const (
+		live    = `{"id":"1","deleted_at":null}`
+		deleted = `{"id":"1","deleted_at":"2021-03-04T05:06:07Z"}`
+	)
+
+	tests := []struct {
+		name          string
+		before, after string
+		want          string
+	}{
+		{name: "insert", after: live, want: changeCreate},
+		{name: "update", before: live, after: live, want: changeUpdate},
+		{name: "delete", before: live, want: changeDelete},
+		{name: "soft delete", before: live, after: deleted, want: changeDelete},
+		{name: "restore", before: deleted, after: live, want: changeCreate},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := changeEventFrom(historyEntry(7, 3, test.before, test.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != test.want {
+				t.Errorf("type = %s, want %s", event.Type, test.want)
+			}
+			if event.Destination == nil {
+				t.Error("no destination")
+			}
+			if want := (changePosition{TransactionID: 7, HistoryID: 3}); event.Position != want {
+				t.Errorf("position = %v, want %v", event.Position, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/bee-travels/bee-travels-go/services/shared/validation"
+	instana "github.com/instana/go-sensor"
+	"os"
+	"time"
+)
+
+// commands are run instead of the web server when their name is given as the
+// first argument.
+var commands = map[string]func(args []string) int{
+	"migrate":  migrateCommand,
+	"purge":    purgeCommand,
+	"seed":     seedCommand,
+	"validate": validateCommand,
+}
+
+func runCommand(name string, args []string) int {
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		return 2
+	}
+	return command(args)
+}
+
+func connect() (database.Pool, error) {
+	return database.NewDatabasePool(server.NewSensor(serviceName, instana.Error))
+}
+
+// validateCommand checks the destination table and prints a JSON report. It
+// exits with 1 if the data has issues, or 2 if it couldn't be checked.
+func validateCommand(_ []string) int {
+	pool, err := connect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	destinations, err := queryAllDestinations(pool, ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report := validation.NewReport("destination")
+	checkDestinations(report, destinations)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
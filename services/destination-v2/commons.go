@@ -25,3 +25,12 @@ func includes(token string) bool {
 	}
 	return false
 }
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	deliveryBatchSize    = 20
+	deliveryTimeout      = 10 * time.Second
+	deliveryPollInterval = 5 * time.Second
+	// deliveryLease is how long a claimed delivery is hidden from other
+	// workers. It outlasts deliveryTimeout, so a worker that dies mid-send
+	// only delays the delivery.
+	deliveryLease       = time.Minute
+	maxDeliveryAttempts = 10
+	minDeliveryBackoff  = 10 * time.Second
+	maxDeliveryBackoff  = time.Hour
+)
+
+// claimDeliveries takes the due deliveries of active subscriptions. Other
+// workers skip the rows being claimed and then, until the lease ends, the
+// claimed ones.
+const claimDeliveries = `
+	UPDATE webhook_delivery d
+	SET attempts = d.attempts + 1, last_attempt_at = now(), next_attempt_at = now() + $2 * interval '1 second'
+	FROM webhook_subscription s
+	WHERE s.id = d.subscription_id
+	  AND d.id IN (
+		SELECT pending.id FROM webhook_delivery pending
+		JOIN webhook_subscription active ON active.id = pending.subscription_id AND active.active
+		WHERE pending.status = 'pending' AND pending.next_attempt_at <= now()
+		ORDER BY pending.next_attempt_at
+		LIMIT $1
+		FOR UPDATE OF pending SKIP LOCKED
+	  )
+	RETURNING d.id, d.history_id, d.event_type, d.attempts, s.url, s.secret`
+
+// deadLetter moves a delivery to webhook_dead_letter.
+const deadLetter = `
+	WITH failed AS (
+		DELETE FROM webhook_delivery WHERE id = $1
+		RETURNING subscription_id, history_id, event_type, attempts, created_at
+	)
+	INSERT INTO webhook_dead_letter (subscription_id, history_id, event_type, attempts, last_status, last_error, created_at)
+	SELECT subscription_id, history_id, event_type, attempts, $2, $3, created_at FROM failed`
+
+type claimedDelivery struct {
+	ID        int64
+	HistoryID int64
+	EventType string
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// deliveryWorker sends queued webhook deliveries. Any number of workers can
+// share a database.
+type deliveryWorker struct {
+	pool   database.Pool
+	client *http.Client
+}
+
+func newDeliveryWorker(pool database.Pool) *deliveryWorker {
+	return &deliveryWorker{
+		pool:   pool,
+		client: &http.Client{Timeout: deliveryTimeout, Transport: webhookTransport()},
+	}
+}
+
+// run delivers until ctx is done. It polls for retries that come due, and
+// wakes up early when the change feed announces a change.
+func (w *deliveryWorker) run(ctx context.Context, feed *changeFeed) {
+	events, unsubscribe := feed.subscribe()
+	defer func() { unsubscribe() }()
+
+	poll := time.NewTicker(deliveryPollInterval)
+	defer poll.Stop()
+
+	for {
+		for {
+			delivered, err := w.deliverDue(ctx)
+			if err != nil {
+				fmt.Printf("webhook delivery failed: %v\n", err)
+				break
+			}
+			if delivered < deliveryBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case _, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; polling catches up.
+				events, unsubscribe = feed.subscribe()
+			}
+		}
+	}
+}
+
+// deliverDue claims a batch of due deliveries and sends them, returning how
+// many it claimed.
+func (w *deliveryWorker) deliverDue(ctx context.Context) (int, error) {
+	claimed, err := w.claim(ctx)
+	if err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+
+	ids := make([]int64, len(claimed))
+	for i, delivery := range claimed {
+		ids[i] = delivery.HistoryID
+	}
+	entries, err := queryHistoryByID(w.pool, ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	events := make(map[int64]ChangeEvent, len(entries))
+	for _, entry := range entries {
+		if event, err := changeEventFrom(entry); err == nil {
+			events[entry.ID] = event
+		}
+	}
+
+	for _, delivery := range claimed {
+		event, ok := events[delivery.HistoryID]
+		if !ok {
+			w.record(ctx, delivery, 0, fmt.Errorf("history entry %d can't be read", delivery.HistoryID))
+			continue
+		}
+		// The subscriber sees the type it filtered on.
+		event.Type = delivery.EventType
+		status, err := w.send(ctx, delivery, event)
+		w.record(ctx, delivery, status, err)
+	}
+	return len(claimed), nil
+}
+
+func (w *deliveryWorker) claim(ctx context.Context) ([]claimedDelivery, error) {
+	rows, err := w.pool.Query(ctx, claimDeliveries, deliveryBatchSize, int(deliveryLease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make([]claimedDelivery, 0)
+	for rows.Next() {
+		var d claimedDelivery
+		if err := rows.Scan(&d.ID, &d.HistoryID, &d.EventType, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, d)
+	}
+	return claimed, rows.Err()
+}
+
+// send posts event to the subscriber. The body is signed with HMAC-SHA256
+// over "<timestamp>.<body>" using the subscription's secret; subscribers
+// should check the signature and reject stale timestamps.
+func (w *deliveryWorker) send(ctx context.Context, delivery claimedDelivery, event ChangeEvent) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", serviceName+"-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+sign(delivery.Secret, timestamp, body))
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("subscriber answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// record stores the outcome of an attempt: the delivery is done, scheduled
+// for a retry, or moved to the dead letters once out of attempts.
+func (w *deliveryWorker) record(ctx context.Context, delivery claimedDelivery, status int, sendErr error) {
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = w.pool.Exec(ctx, `
+			UPDATE webhook_delivery
+			SET status = 'delivered', delivered_at = now(), last_status = $2, last_error = NULL
+			WHERE id = $1`, delivery.ID, lastStatus)
+	case delivery.Attempts >= maxDeliveryAttempts:
+		err = database.WithTxContext(w.pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+			_, err := tx.Exec(ctx, deadLetter, delivery.ID, lastStatus, sendErr.Error())
+			return err
+		})
+	default:
+		_, err = w.pool.Exec(ctx, `
+			UPDATE webhook_delivery
+			SET next_attempt_at = now() + $2 * interval '1 millisecond', last_status = $3, last_error = $4
+			WHERE id = $1`, delivery.ID, deliveryBackoff(delivery.Attempts).Milliseconds(), lastStatus, sendErr.Error())
+	}
+	if err != nil {
+		fmt.Printf("couldn't record webhook delivery %d: %v\n", delivery.ID, err)
+	}
+}
+
+// deliveryBackoff doubles the wait after every failed attempt, with jitter
+// so that a subscriber coming back up isn't hit by every retry at once.
+func deliveryBackoff(attempts int) time.Duration {
+	backoff := maxDeliveryBackoff
+	if attempts < 20 {
+		if b := minDeliveryBackoff << uint(attempts-1); b < maxDeliveryBackoff {
+			backoff = b
+		}
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestDeliveryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{attempts: 1, max: minDeliveryBackoff},
+		{attempts: 2, max: 2 * minDeliveryBackoff},
+		{attempts: 3, max: 4 * minDeliveryBackoff},
+		{attempts: 9, max: 256 * minDeliveryBackoff},
+		{attempts: 10, max: maxDeliveryBackoff},
+		{attempts: 19, max: maxDeliveryBackoff},
+		{attempts: 64, max: maxDeliveryBackoff},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			got := deliveryBackoff(test.attempts)
+			if got < test.max/2 || got > test.max {
+				t.Fatalf("deliveryBackoff(%d) = %v, want between %v and %v", test.attempts, got, test.max/2, test.max)
+			}
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("0123456789abcdef"))
+	mac.Write([]byte("1600000000.{}"))
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := sign("0123456789abcdef", "1600000000", []byte("{}")); got != want {
+		t.Errorf("sign() = %s, want %s", got, want)
+	}
+	if got := sign("another secret!!", "1600000000", []byte("{}")); got == want {
+		t.Error("sign() doesn't depend on the secret")
+	}
+	if got := sign("0123456789abcdef", "1600000001", []byte("{}")); got == want {
+		t.Error("sign() doesn't depend on the timestamp")
+	}
+}
+
+func TestWebhookInputApply(t *testing.T) {
+	text := func(s string) *string { return &s }
+	list := func(s ...string) *[]string { return &s }
+
+	tests := []struct {
+		name    string
+		in      webhookInput
+		want    WebhookSubscription
+		wantErr bool
+	}{
+		{name: "url", in: webhookInput{URL: text("https://example.com/hook")}, want: WebhookSubscription{URL: "https://example.com/hook"}},
+		{name: "relative url", in: webhookInput{URL: text("/hook")}, wantErr: true},
+		{name: "other scheme", in: webhookInput{URL: text("ftp://example.com/hook")}, wantErr: true},
+		{name: "short secret", in: webhookInput{Secret: text("short")}, wantErr: true},
+		{name: "events", in: webhookInput{Events: list(changeCreate, changeDelete)}, want: WebhookSubscription{Events: []string{changeCreate, changeDelete}}},
+		{name: "unknown event", in: webhookInput{Events: list("rename")}, wantErr: true},
+		{name: "countries", in: webhookInput{Countries: list(" France", "ITALY")}, want: WebhookSubscription{Countries: []string{"france", "italy"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got WebhookSubscription
+			err := test.in.apply(&got)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("apply() error = %v, want error: %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got.URL != test.want.URL || !sameStrings(got.Events, test.want.Events) || !sameStrings(got.Countries, test.want.Countries) {
+				t.Errorf("apply() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+)
+
+// blockedNetworks are the addresses webhooks may not reach: whoever can
+// register a URL could otherwise make the service call its own network, the
+// cloud metadata endpoint among others.
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, including cloud metadata
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // IETF protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved, including broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4 translation, which can reach the above
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// privateWebhooksAllowed reports whether WEBHOOK_ALLOW_PRIVATE_NETWORKS
+// lifts the restriction, for development against a local subscriber.
+func privateWebhooksAllowed() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+}
+
+// publicAddress reports whether webhooks may be sent to ip.
+func publicAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookURL refuses a subscription URL whose host resolves to an
+// address webhooks may not reach. Deliveries check the address again when
+// they connect, since the host may resolve differently by then.
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	if privateWebhooksAllowed() {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("url host can't be resolved: %v", err)
+	}
+	for _, address := range addresses {
+		if !publicAddress(address.IP) {
+			return fmt.Errorf("url host resolves to %s, which webhooks may not reach", address.IP)
+		}
+	}
+	return nil
+}
+
+// webhookTransport connects only to addresses webhooks may reach. The check
+// runs on the address being dialed, after resolution, so a host can't pass
+// it and then resolve to another address; it also applies to redirects. No
+// proxy is used, which would hide the address.
+func webhookTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: deliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if privateWebhooksAllowed() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("webhooks may not reach %s", host)
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   deliveryTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
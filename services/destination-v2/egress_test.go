@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// withPrivateWebhooks sets WEBHOOK_ALLOW_PRIVATE_NETWORKS for the duration
+// of a test.
+func withPrivateWebhooks(t *testing.T, allowed bool) {
+	previous, set := os.LookupEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+	if allowed {
+		os.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	} else {
+		os.Unsetenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+	}
+	t.Cleanup(func() {
+		if set {
+			os.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", previous)
+		} else {
+			os.Unsetenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+		}
+	})
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "10.1.2.3"},
+		{ip: "172.16.0.1"},
+		{ip: "172.32.0.1", want: true},
+		{ip: "192.168.1.1"},
+		{ip: "169.254.169.254"},
+		{ip: "100.64.0.1"},
+		{ip: "0.0.0.0"},
+		{ip: "224.0.0.1"},
+		{ip: "255.255.255.255"},
+		{ip: "::1"},
+		{ip: "::"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "::ffff:169.254.169.254"},
+		{ip: "64:ff9b::a9fe:a9fe"},
+		{ip: "fd00::1"},
+		{ip: "fe80::1"},
+		{ip: "ff02::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if got := publicAddress(net.ParseIP(test.ip)); got != test.want {
+				t.Errorf("publicAddress(%s) = %v, want %v", test.ip, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		allowed bool
+		wantErr bool
+	}{
+		{name: "public address", url: "https://93.184.216.34/hook"},
+		{name: "public address with port", url: "https://93.184.216.34:8443/hook"},
+		{name: "loopback", url: "http://127.0.0.1:8080/hook", wantErr: true},
+		{name: "metadata endpoint", url: "http://169.254.169.254/latest/meta-data", wantErr: true},
+		{name: "IPv6 loopback", url: "http://[::1]/hook", wantErr: true},
+		{name: "localhost", url: "http://localhost/hook", wantErr: true},
+		{name: "loopback when allowed", url: "http://127.0.0.1:8080/hook", allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withPrivateWebhooks(t, test.allowed)
+			err := checkWebhookURL(context.Background(), test.url)
+			if (err != nil) != test.wantErr {
+				t.Errorf("checkWebhookURL(%s) error = %v, want error: %v", test.url, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookTransport(t *testing.T) {
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer subscriber.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		allowed bool
+		wantErr bool
+	}{
+		{name: "loopback", url: subscriber.URL, wantErr: true},
+		{name: "loopback when allowed", url: subscriber.URL, allowed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withPrivateWebhooks(t, test.allowed)
+			client := &http.Client{Transport: webhookTransport()}
+			res, err := client.Post(test.url, "application/json", nil)
+			if err == nil {
+				res.Body.Close()
+			}
+			if (err != nil) != test.wantErr {
+				t.Errorf("POST %s error = %v, want error: %v", test.url, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -1,18 +1,21 @@
 module github.com/bee-travels/bee-travels-go/services/destination-v2
 
-go 1.14
+go 1.16
 
 require (
 	github.com/Joker/hpp v1.0.0 // indirect
+	github.com/bee-travels/bee-travels-go/services/shared v0.0.0
 	github.com/elgris/sqrl v0.0.0-20190909141434-5a439265eeec
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/instana/go-sensor v1.29.0
 	github.com/iris-contrib/middleware/cors v0.0.0-20210110101738-6d0a4d799b5d
 	github.com/jackc/pgconn v1.8.1
 	github.com/jackc/pgproto3/v2 v2.0.6
+	github.com/jackc/pgtype v1.7.0
 	github.com/jackc/pgx/v4 v4.11.0
 	github.com/k0kubun/colorstring v0.0.0-20150214042306-9440f1994b88 // indirect
 	github.com/kataras/iris/v12 v12.2.0-alpha2
+	github.com/nats-io/nats.go v1.11.0
 	github.com/opentracing/opentracing-go v1.2.0
 	github.com/pkg/errors v0.8.1
 	github.com/yudai/pp v2.0.1+incompatible // indirect
@@ -20,3 +23,5 @@ require (
 )
 
 replace github.com/russross/blackfriday/v2 => gopkg.in/russross/blackfriday.v2 v2.1.0
+
+replace github.com/bee-travels/bee-travels-go/services/shared => ../shared
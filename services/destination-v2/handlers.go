@@ -1,49 +1,314 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/bee-travels/bee-travels-go/services/shared/validation"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-func listDestinations(pool database.Pool) server.RequestHandler {
+func listDestinations(repository Repository) server.RequestHandler {
 	return func(ctx server.RequestContext) {
-		location, err := queryLocations(pool, ctx, "")
+		scope, ok := readScopeFromRequest(ctx)
+		if !ok {
+			return
+		}
+		location, err := repository.Locations(ctx, "", scope)
 		if err != nil {
-			server.Response(ctx, http.StatusForbidden, Error{
-				Error: err.Error(),
-			})
+			requestFailed(ctx, http.StatusForbidden, err)
 			return
 		}
 		server.Response(ctx, http.StatusOK, location)
 	}
 }
 
-func listDestinationsByCountry(pool database.Pool) server.RequestHandler {
+func listDestinationsByCountry(repository Repository) server.RequestHandler {
 	return func(ctx server.RequestContext) {
 		country := ctx.Params().Get("country")
-		location, err := queryLocations(pool, ctx, capitalize(country))
+		scope, ok := readScopeFromRequest(ctx)
+		if !ok {
+			return
+		}
+		location, err := repository.Locations(ctx, capitalize(country), scope)
 		if err != nil {
-			server.Response(ctx, http.StatusForbidden, Error{
-				Error: err.Error(),
-			})
+			requestFailed(ctx, http.StatusForbidden, err)
 			return
 		}
 		server.Response(ctx, http.StatusOK, location)
 	}
 }
 
-func listDestinationByCountryAndCity(pool database.Pool) server.RequestHandler {
+func listDestinationByCountryAndCity(repository Repository) server.RequestHandler {
 	return func(ctx server.RequestContext) {
 		country := ctx.Params().Get("country")
 		city := ctx.Params().Get("city")
-		destinations, err := queryDestinations(pool, ctx, capitalize(country), capitalize(city))
+		scope, ok := readScopeFromRequest(ctx)
+		if !ok {
+			return
+		}
+		destinations, err := repository.Destinations(ctx, capitalize(country), capitalize(city), scope)
 		if err != nil {
-			server.Response(ctx, http.StatusForbidden, Error{
+			requestFailed(ctx, http.StatusForbidden, err)
+			return
+		}
+		server.ResponseWithETag(ctx, http.StatusOK, destinationsETag(destinations), destinations)
+	}
+}
+
+// replaceDestination handles PUT: the body replaces every field but the id.
+func replaceDestination(repository Repository) server.RequestHandler {
+	return updateDestinationHandler(repository, func(current Destination, body []byte) (Destination, error) {
+		var replacement Destination
+		if err := json.Unmarshal(body, &replacement); err != nil {
+			return current, err
+		}
+		if replacement.ID != "" && replacement.ID != current.ID {
+			return current, fmt.Errorf("id can't be changed")
+		}
+		replacement.ID = current.ID
+		return replacement, nil
+	})
+}
+
+// patchDestination handles PATCH as a JSON merge patch: only the fields
+// present in the body change.
+func patchDestination(repository Repository) server.RequestHandler {
+	return updateDestinationHandler(repository, func(current Destination, body []byte) (Destination, error) {
+		id := current.ID
+		if err := json.Unmarshal(body, &current); err != nil {
+			return current, err
+		}
+		if current.ID != id {
+			return current, fmt.Errorf("id can't be changed")
+		}
+		return current, nil
+	})
+}
+
+// updateDestinationHandler applies an update to the destination at
+// /{country}/{city}. The request must carry the destination's current ETag
+// in If-Match, so that concurrent edits are rejected instead of overwritten.
+func updateDestinationHandler(repository Repository, apply func(current Destination, body []byte) (Destination, error)) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		country := ctx.Params().Get("country")
+		city := ctx.Params().Get("city")
+		destinations, err := repository.Destinations(ctx, capitalize(country), capitalize(city), readScope{})
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		current, ok := singleDestination(ctx, destinations)
+		if !ok {
+			return
+		}
+
+		ifMatch, ok := server.IfMatch(ctx)
+		if !ok {
+			server.Response(ctx, http.StatusPreconditionRequired, Error{
+				Error: "If-Match header with the destination's ETag is required",
+			})
+			return
+		}
+		if !server.StrongETagMatches(ifMatch, destinationsETag(destinations)) {
+			server.Response(ctx, http.StatusPreconditionFailed, Error{
+				Error: "destination has been modified",
+			})
+			return
+		}
+
+		body, err := ioutil.ReadAll(ctx.Request().Body)
+		if err != nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
 				Error: err.Error(),
 			})
 			return
 		}
-		server.Response(ctx, http.StatusOK, destinations)
+		updated, err := apply(current, body)
+		if err != nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
+				Error: err.Error(),
+			})
+			return
+		}
+		if updated.Images == nil {
+			updated.Images = make([]string, 0)
+		}
+		// Only live destinations are updated, whatever the body says; a
+		// deletedAt would otherwise reach the response and the event.
+		updated.DeletedAt = nil
+
+		report := validation.NewReport(current.ID)
+		checkDestinations(report, []Destination{updated})
+		if !report.Valid {
+			server.Response(ctx, http.StatusUnprocessableEntity, report)
+			return
+		}
+
+		updated, err = repository.Update(ctx, auditFromRequest(ctx), current, updated)
+		if err == errVersionConflict {
+			server.Response(ctx, http.StatusPreconditionFailed, Error{
+				Error: "destination has been modified",
+			})
+			return
+		}
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		server.ResponseWithETag(ctx, http.StatusOK, destinationsETag([]Destination{updated}), updated)
+	}
+}
+
+// deleteDestination soft-deletes the destination at /{country}/{city}. Its
+// row is kept so that bookings can still resolve its id; the purge command
+// removes it once the retention period is over. An If-Match header is
+// honoured but not required.
+func deleteDestination(repository Repository) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		country := ctx.Params().Get("country")
+		city := ctx.Params().Get("city")
+		destinations, err := repository.Destinations(ctx, capitalize(country), capitalize(city), readScope{})
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		current, ok := singleDestination(ctx, destinations)
+		if !ok {
+			return
+		}
+		if ifMatch, ok := server.IfMatch(ctx); ok && !server.StrongETagMatches(ifMatch, destinationsETag(destinations)) {
+			server.Response(ctx, http.StatusPreconditionFailed, Error{
+				Error: "destination has been modified",
+			})
+			return
+		}
+
+		if _, ok := setDeleted(repository, ctx, current, true); ok {
+			ctx.StatusCode(http.StatusNoContent)
+		}
+	}
+}
+
+// restoreDestination undeletes the soft-deleted destination at
+// /{country}/{city}.
+func restoreDestination(repository Repository) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		country := ctx.Params().Get("country")
+		city := ctx.Params().Get("city")
+		destinations, err := repository.Destinations(ctx, capitalize(country), capitalize(city), readScope{IncludeDeleted: true})
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		deleted := make([]Destination, 0, len(destinations))
+		for _, destination := range destinations {
+			if destination.DeletedAt != nil {
+				deleted = append(deleted, destination)
+			}
+		}
+		current, ok := singleDestination(ctx, deleted)
+		if !ok {
+			return
+		}
+
+		if restored, ok := setDeleted(repository, ctx, current, false); ok {
+			server.ResponseWithETag(ctx, http.StatusOK, destinationsETag([]Destination{restored}), restored)
+		}
+	}
+}
+
+// requestFailed answers a failed request with status, 501 when the storage
+// backend can't serve it, or 503 while the database is unavailable.
+func requestFailed(ctx server.RequestContext, status int, err error) {
+	if err == errUnsupported {
+		status = http.StatusNotImplemented
+	}
+	if unavailable, ok := database.Unavailable(err); ok {
+		status = http.StatusServiceUnavailable
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(unavailable.RetryAfter.Seconds()))))
+	}
+	server.Response(ctx, status, Error{
+		Error: err.Error(),
+	})
+}
+
+// singleDestination answers the request itself unless destinations holds
+// exactly one destination.
+func singleDestination(ctx server.RequestContext, destinations []Destination) (Destination, bool) {
+	switch len(destinations) {
+	case 0:
+		server.Response(ctx, http.StatusNotFound, Error{
+			Error: "destination not found",
+		})
+		return Destination{}, false
+	case 1:
+		return destinations[0], true
+	default:
+		server.Response(ctx, http.StatusConflict, Error{
+			Error: "several destinations match, update them through the import endpoint",
+		})
+		return Destination{}, false
+	}
+}
+
+// setDeleted soft-deletes or restores d, answering the request itself when
+// that fails.
+func setDeleted(repository Repository, ctx server.RequestContext, d Destination, deleted bool) (Destination, bool) {
+	d, err := repository.SetDeleted(ctx, auditFromRequest(ctx), d, deleted)
+	if err == errVersionConflict {
+		server.Response(ctx, http.StatusPreconditionFailed, Error{
+			Error: "destination has been modified",
+		})
+		return d, false
+	}
+	if err != nil {
+		requestFailed(ctx, http.StatusInternalServerError, err)
+		return d, false
+	}
+	return d, true
+}
+
+// readScopeFromRequest reads the as_of and include_deleted query parameters,
+// answering the request itself when they are invalid. as_of is an RFC 3339
+// timestamp; include_deleted is reserved to admins.
+func readScopeFromRequest(ctx server.RequestContext) (readScope, bool) {
+	var scope readScope
+	if value := ctx.URLParam("as_of"); value != "" {
+		asOf, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
+				Error: err.Error(),
+			})
+			return scope, false
+		}
+		scope.AsOf = &asOf
+	}
+	if ctx.URLParamDefault("include_deleted", "false") == "true" {
+		if !isAdmin(ctx) {
+			server.Response(ctx, http.StatusForbidden, Error{
+				Error: "include_deleted requires an admin token",
+			})
+			return scope, false
+		}
+		scope.IncludeDeleted = true
+	}
+	return scope, true
+}
+
+// destinationsETag identifies a set of destinations by their ids and
+// versions, which change whenever their content does.
+func destinationsETag(destinations []Destination) string {
+	hash := sha1.New()
+	for _, destination := range destinations {
+		fmt.Fprintf(hash, "%s:%d;", destination.ID, destination.Version)
 	}
+	return server.ETag(hex.EncodeToString(hash.Sum(nil))[:20])
 }
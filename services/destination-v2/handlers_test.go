@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/kataras/iris/v12"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDestinationsETag(t *testing.T) {
+	paris := Destination{ID: "1", City: "Paris", Version: 1}
+	rome := Destination{ID: "2", City: "Rome", Version: 4}
+	parisV2 := paris
+	parisV2.Version = 2
+	renamed := paris
+	renamed.City = "Lyon"
+
+	tests := []struct {
+		name string
+		a, b []Destination
+		same bool
+	}{
+		{name: "same versions", a: []Destination{paris, rome}, b: []Destination{paris, rome}, same: true},
+		{name: "fields other than id and version don't count", a: []Destination{paris}, b: []Destination{renamed}, same: true},
+		{name: "new version", a: []Destination{paris}, b: []Destination{parisV2}},
+		{name: "another destination", a: []Destination{paris}, b: []Destination{rome}},
+		{name: "order", a: []Destination{paris, rome}, b: []Destination{rome, paris}},
+		{name: "added destination", a: []Destination{paris}, b: []Destination{paris, rome}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := destinationsETag(test.a), destinationsETag(test.b)
+			if (a == b) != test.same {
+				t.Errorf("ETags %s and %s, want them to be the same: %v", a, b, test.same)
+			}
+		})
+	}
+}
+
+// echoingRepository records the destinations it is asked to update, and
+// returns them as stored, as the Postgres backend does.
+type echoingRepository struct {
+	*memoryRepository
+	updates []Destination
+}
+
+func (r *echoingRepository) Update(_ server.RequestContext, _ Audit, previous, d Destination) (Destination, error) {
+	r.updates = append(r.updates, d)
+	d.Version = previous.Version + 1
+	return d, nil
+}
+
+func TestUpdateDestinationIgnoresDeletedAt(t *testing.T) {
+	paris := Destination{ID: "1", City: "Paris", Country: "France", Latitude: 48.85, Longitude: 2.35, Population: 2161000, Description: "Capital of France", Images: []string{}}
+
+	tests := []struct {
+		name    string
+		method  string
+		handler func(repository Repository) server.RequestHandler
+		body    string
+	}{
+		{
+			name:    "patch",
+			method:  http.MethodPatch,
+			handler: patchDestination,
+			body:    `{"population": 2200000, "deletedAt": "2020-01-01T00:00:00Z"}`,
+		},
+		{
+			name:    "put",
+			method:  http.MethodPut,
+			handler: replaceDestination,
+			body: `{"city": "Paris", "country": "France", "latitude": 48.85, "longitude": 2.35, "population": 2200000,
+				"description": "Capital of France", "deletedAt": "2020-01-01T00:00:00Z"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := &echoingRepository{memoryRepository: newMemoryRepository([]Destination{paris}, nil)}
+			current, _ := repository.find("1")
+
+			app := iris.New()
+			app.Handle(test.method, "/{country}/{city}", test.handler(repository))
+			if err := app.Build(); err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(test.method, "/france/paris", strings.NewReader(test.body))
+			request.Header.Set("If-Match", destinationsETag([]Destination{current}))
+			recorder := httptest.NewRecorder()
+			app.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+			}
+			if len(repository.updates) != 1 || repository.updates[0].DeletedAt != nil {
+				t.Errorf("updated with %+v, want no deletedAt", repository.updates)
+			}
+			var response map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := response["deletedAt"]; ok {
+				t.Errorf("response %s has a deletedAt", recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/elgris/sqrl"
+	"github.com/jackc/pgx/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Audit names who makes a change and why. The destination_history trigger
+// reads it from the transaction's settings. ClaimedActor is a name the
+// client gave without proof, kept next to the actor but never instead of it.
+type Audit struct {
+	Actor        string
+	ClaimedActor string
+	Reason       string
+}
+
+// auditFromRequest attributes a change to the identity the request
+// authenticated as. The X-Actor header is only recorded as a claim.
+func auditFromRequest(ctx server.RequestContext) Audit {
+	return Audit{
+		Actor:        principal(ctx),
+		ClaimedActor: ctx.GetHeader("X-Actor"),
+		Reason:       ctx.GetHeader("X-Change-Reason"),
+	}
+}
+
+// record attaches the audit to the history entries tx writes. The settings
+// are local to tx, so they can't leak to the next user of the connection.
+func (a Audit) record(tx pgxpool.Tx, ctx context.Context) error {
+	_, err := tx.Exec(ctx, "SELECT set_config('app.actor', $1, true), set_config('app.claimed_actor', $2, true), set_config('app.reason', $3, true)", a.Actor, a.ClaimedActor, a.Reason)
+	return err
+}
+
+func listDestinationHistory(repository Repository) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		country := ctx.Params().Get("country")
+		city := ctx.Params().Get("city")
+		entries, err := repository.History(ctx, capitalize(country), capitalize(city))
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if len(entries) == 0 {
+			server.Response(ctx, http.StatusNotFound, Error{
+				Error: "no history for this destination",
+			})
+			return
+		}
+		server.Response(ctx, http.StatusOK, entries)
+	}
+}
+
+// queryHistory returns, most recent first, the history of the destination
+// at country and city, deleted or not. The destination is looked up in its
+// table, whose location is indexed, rather than in the history.
+func queryHistory(pool database.Pool, ctx server.RequestContext, country, city string) ([]HistoryEntry, error) {
+	selector := database.QueryBuilder().
+		Select(historyColumns...).
+		From("destination_history").
+		Where("destination_id IN (SELECT id FROM destination WHERE country = ? AND city = ?)", country, city).
+		OrderBy("changed_at DESC", "id DESC")
+
+	entries := make([]HistoryEntry, 0)
+	err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
+		entry, err := scanHistoryEntry(row)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// queryHistoryAfter returns, in commit order, up to limit entries after
+// position. Entries of transactions that could still be followed by an entry
+// before them are left for a later read.
+func queryHistoryAfter(pool database.Pool, ctx context.Context, position changePosition, limit uint64) ([]HistoryEntry, error) {
+	return readHistory(pool, ctx, database.QueryBuilder().
+		Select(historyColumns...).
+		From("destination_history").
+		Where("(transaction_id, id) > (?::text::xid8, ?)", strconv.FormatInt(position.TransactionID, 10), position.HistoryID).
+		Where(settledTransactions).
+		OrderBy("transaction_id", "id").
+		Limit(limit))
+}
+
+// queryLatestPosition returns the position of the last entry that can be
+// read in commit order, which is where the feed starts.
+func queryLatestPosition(pool database.Pool, ctx context.Context) (changePosition, error) {
+	entries, err := readHistory(pool, ctx, database.QueryBuilder().
+		Select(historyColumns...).
+		From("destination_history").
+		Where(settledTransactions).
+		OrderBy("transaction_id DESC", "id DESC").
+		Limit(1))
+	if err != nil || len(entries) == 0 {
+		return changePosition{}, err
+	}
+	return positionOf(entries[0]), nil
+}
+
+// settledTransactions keeps the entries of transactions older than every
+// transaction in progress, which can't be followed by an entry before them.
+const settledTransactions = "transaction_id < pg_snapshot_xmin(pg_current_snapshot())"
+
+// queryHistoryByID returns the entries among ids, in order.
+func queryHistoryByID(pool database.Pool, ctx context.Context, ids []int64) ([]HistoryEntry, error) {
+	return readHistory(pool, ctx, database.QueryBuilder().
+		Select(historyColumns...).
+		From("destination_history").
+		Where("id = ANY(?)", ids).
+		OrderBy("id"))
+}
+
+// readHistory runs selector outside of a request, for the change feed.
+func readHistory(pool database.Pool, ctx context.Context, selector *sqrl.SelectBuilder) ([]HistoryEntry, error) {
+	sql, args, err := selector.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+var historyColumns = []string{"id", "transaction_id::text::bigint", "destination_id", "operation", "actor", "claimed_actor", "reason", "changed_at", "before", "after"}
+
+func scanHistoryEntry(row pgx.Row) (HistoryEntry, error) {
+	var entry HistoryEntry
+	var before, after []byte
+	err := row.Scan(&entry.ID, &entry.TransactionID, &entry.DestinationID, &entry.Operation, &entry.Actor, &entry.ClaimedActor, &entry.Reason, &entry.ChangedAt, &before, &after)
+	entry.Before, entry.After = json.RawMessage(before), json.RawMessage(after)
+	return entry, err
+}
+
+// historyDestination decodes the before or after row of a history entry,
+// which is nil when there is none.
+func historyDestination(raw json.RawMessage) (*Destination, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var row struct {
+		Destination
+		DeletedAt *time.Time `json:"deleted_at"`
+	}
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return nil, err
+	}
+	row.Destination.DeletedAt = row.DeletedAt
+	return &row.Destination, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newRequestContext returns the context of a GET request with headers, as
+// the handlers see it.
+func newRequestContext(headers map[string]string) server.RequestContext {
+	request := httptest.NewRequest("GET", "/", nil)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	ctx := context.NewContext(iris.New())
+	ctx.BeginRequest(httptest.NewRecorder(), request)
+	return ctx
+}
+
+// withAdminToken sets ADMIN_TOKEN for the duration of a test.
+func withAdminToken(t *testing.T, token string) {
+	previous, set := os.LookupEnv("ADMIN_TOKEN")
+	os.Setenv("ADMIN_TOKEN", token)
+	t.Cleanup(func() {
+		if set {
+			os.Setenv("ADMIN_TOKEN", previous)
+		} else {
+			os.Unsetenv("ADMIN_TOKEN")
+		}
+	})
+}
+
+func TestAuditFromRequest(t *testing.T) {
+	withAdminToken(t, "secret")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Audit
+	}{
+		{
+			name:    "admin",
+			headers: map[string]string{"Authorization": "Bearer secret", "X-Change-Reason": "typo"},
+			want:    Audit{Actor: "admin", Reason: "typo"},
+		},
+		{
+			name:    "admin claiming a name",
+			headers: map[string]string{"Authorization": "Bearer secret", "X-Actor": "alice"},
+			want:    Audit{Actor: "admin", ClaimedActor: "alice"},
+		},
+		{
+			name:    "anonymous claiming a name",
+			headers: map[string]string{"X-Actor": "alice"},
+			want:    Audit{ClaimedActor: "alice"},
+		},
+		{
+			name:    "wrong token",
+			headers: map[string]string{"Authorization": "Bearer guess", "X-Actor": "admin"},
+			want:    Audit{ClaimedActor: "admin"},
+		},
+		{
+			name:    "not a bearer token",
+			headers: map[string]string{"Authorization": "secret"},
+			want:    Audit{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := auditFromRequest(newRequestContext(test.headers)); got != test.want {
+				t.Errorf("auditFromRequest() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrincipalWithoutAdminToken(t *testing.T) {
+	withAdminToken(t, "")
+
+	ctx := newRequestContext(map[string]string{"Authorization": "Bearer "})
+	if got := principal(ctx); got != "" {
+		t.Errorf("principal() = %q without ADMIN_TOKEN, want none", got)
+	}
+}
+
+func TestHistoryDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    *Destination
+		deleted bool
+		wantErr bool
+	}{
+		{name: "none", raw: ""},
+		{name: "null", raw: "null"},
+		{name: "row", raw: `{"id":"1","city":"Paris","country":"France","deleted_at":null}`, want: &Destination{ID: "1", City: "Paris", Country: "France"}},
+		{name: "deleted row", raw: `{"id":"1","city":"Paris","deleted_at":"2021-03-04T05:06:07Z"}`, want: &Destination{ID: "1", City: "Paris"}, deleted: true},
+		{name: "invalid", raw: `{"id":`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := historyDestination(json.RawMessage(test.raw))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("historyDestination() error = %v, want error: %v", err, test.wantErr)
+			}
+			if test.want == nil {
+				if got != nil && !test.wantErr {
+					t.Errorf("historyDestination() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("historyDestination() = nil")
+			}
+			if got.ID != test.want.ID || got.City != test.want.City || got.Country != test.want.Country {
+				t.Errorf("historyDestination() = %+v, want %+v", got, test.want)
+			}
+			if (got.DeletedAt != nil) != test.deleted {
+				t.Errorf("historyDestination() deleted at %v, want deleted: %v", got.DeletedAt, test.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/bee-travels/bee-travels-go/services/shared/validation"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+)
+
+const (
+	// maxImportRows keeps an import within the bind parameter limit of a
+	// single upsert statement.
+	maxImportRows = 5000
+	maxImportSize = 32 << 20
+)
+
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+)
+
+type ImportRowError struct {
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportChange struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+type ImportResult struct {
+	DryRun    bool             `json:"dryRun"`
+	Total     int              `json:"total"`
+	Created   int              `json:"created"`
+	Updated   int              `json:"updated"`
+	Unchanged int              `json:"unchanged"`
+	Changes   []ImportChange   `json:"changes,omitempty"`
+	Errors    []ImportRowError `json:"errors"`
+}
+
+// importDestinations accepts a JSON array or an NDJSON stream of
+// destinations. Every row is validated first; if any fails nothing is
+// written. With dry_run=true the changes are only reported.
+func importDestinations(repository Repository) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		dryRun := ctx.URLParamDefault("dry_run", "false") == "true"
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(ctx.ResponseWriter(), ctx.Request().Body, maxImportSize))
+		if err != nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
+				Error: err.Error(),
+			})
+			return
+		}
+
+		destinations, rowErrors, err := decodeImport(body)
+		if err != nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
+				Error: err.Error(),
+			})
+			return
+		}
+
+		result := ImportResult{
+			DryRun: dryRun,
+			Total:  len(destinations),
+			Errors: validateImport(destinations, rowErrors),
+		}
+		if len(result.Errors) > 0 {
+			server.Response(ctx, http.StatusUnprocessableEntity, result)
+			return
+		}
+
+		ids := make([]string, len(destinations))
+		for i, destination := range destinations {
+			ids[i] = destination.ID
+		}
+		existing, err := repository.DestinationsByID(ctx, ids)
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+
+		changed := make([]Destination, 0, len(destinations))
+		for i, destination := range destinations {
+			current, ok := existing[destination.ID]
+			switch {
+			case !ok:
+				result.Changes = append(result.Changes, ImportChange{Index: i, ID: destination.ID, Action: actionCreate})
+				result.Created++
+			case !sameDestination(current, destination) || current.DeletedAt != nil:
+				// Importing a soft-deleted destination restores it.
+				result.Changes = append(result.Changes, ImportChange{Index: i, ID: destination.ID, Action: actionUpdate})
+				result.Updated++
+			default:
+				result.Unchanged++
+				continue
+			}
+			changed = append(changed, destination)
+		}
+
+		if dryRun || len(changed) == 0 {
+			server.Response(ctx, http.StatusOK, result)
+			return
+		}
+
+		// The counts written are authoritative; someone may have edited the
+		// same destinations since they were compared above.
+		written, err := repository.Import(ctx, auditFromRequest(ctx), changed, existing)
+		result.Created, result.Updated = countUpserts(written)
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		result.Unchanged = result.Total - result.Created - result.Updated
+		server.Response(ctx, http.StatusOK, result)
+	}
+}
+
+// decodeImport reads a JSON array when the body starts with '[' and NDJSON
+// otherwise. Rows that can't be decoded are reported by index; only a body
+// that can't be split into rows at all is an error.
+func decodeImport(body []byte) ([]Destination, []ImportRowError, error) {
+	raw := make([]json.RawMessage, 0)
+
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+	} else {
+		reader := bufio.NewReader(bytes.NewReader(trimmed))
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				raw = append(raw, json.RawMessage(line))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, nil, fmt.Errorf("no destinations to import")
+	}
+	if len(raw) > maxImportRows {
+		return nil, nil, fmt.Errorf("%d destinations exceed the limit of %d per import", len(raw), maxImportRows)
+	}
+
+	destinations := make([]Destination, len(raw))
+	rowErrors := make([]ImportRowError, 0)
+	for i, row := range raw {
+		if err := json.Unmarshal(row, &destinations[i]); err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Index: i, Error: err.Error()})
+			continue
+		}
+		if destinations[i].Images == nil {
+			destinations[i].Images = make([]string, 0)
+		}
+	}
+	return destinations, rowErrors, nil
+}
+
+// validateImport applies the validate command's rules to the batch and adds
+// their issues to the rows' decoding errors. Rows that failed to decode are
+// only reported once.
+func validateImport(destinations []Destination, rowErrors []ImportRowError) []ImportRowError {
+	undecoded := make(map[int]bool, len(rowErrors))
+	for _, rowError := range rowErrors {
+		undecoded[rowError.Index] = true
+	}
+
+	report := validation.NewReport("import")
+	checkDestinations(report, destinations)
+
+	for _, issue := range report.Issues {
+		if undecoded[issue.Index] {
+			continue
+		}
+		rowErrors = append(rowErrors, ImportRowError{
+			Index: issue.Index,
+			ID:    issue.ID,
+			Error: fmt.Sprintf("%s: %s", issue.Rule, issue.Message),
+		})
+	}
+	sort.SliceStable(rowErrors, func(i, j int) bool {
+		return rowErrors[i].Index < rowErrors[j].Index
+	})
+	return rowErrors
+}
+
+// importChanges describes the rows an import wrote. Soft-deleted rows that
+// were imported again are restored.
+func importChanges(destinations []Destination, existing map[string]Destination, written []upserted) []destinationChange {
+	byID := make(map[string]Destination, len(destinations))
+	for _, destination := range destinations {
+		byID[destination.ID] = destination
+	}
+
+	changes := make([]destinationChange, 0, len(written))
+	for _, u := range written {
+		destination := byID[u.ID]
+		destination.Version = u.Version
+		change := destinationChange{Type: eventDestinationCreated, ID: u.ID, Version: u.Version, Destination: &destination}
+		if previous, ok := existing[u.ID]; ok && !u.Inserted {
+			change.Type = eventDestinationUpdated
+			if previous.DeletedAt != nil {
+				change.Type = eventDestinationRestored
+			}
+			change.Previous = &previous
+		} else if !u.Inserted {
+			change.Type = eventDestinationUpdated
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func sameDestination(a, b Destination) bool {
+	if a.ID != b.ID || a.City != b.City || a.Country != b.Country ||
+		a.Latitude != b.Latitude || a.Longitude != b.Longitude ||
+		a.Population != b.Population || a.Description != b.Description ||
+		len(a.Images) != len(b.Images) {
+		return false
+	}
+	for i := range a.Images {
+		if a.Images[i] != b.Images[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeImport(t *testing.T) {
+	paris := `{"id": "1", "city": "Paris", "country": "France"}`
+	rome := `{"id": "2", "city": "Rome", "country": "Italy"}`
+
+	tests := []struct {
+		name       string
+		body       string
+		wantIDs    []string
+		wantErrors []int
+		wantErr    string
+	}{
+		{name: "array", body: "[" + paris + "," + rome + "]", wantIDs: []string{"1", "2"}},
+		{name: "ndjson", body: paris + "\n\n" + rome + "\n", wantIDs: []string{"1", "2"}},
+		{name: "ndjson without final newline", body: "  " + paris + "\r\n" + rome, wantIDs: []string{"1", "2"}},
+		{name: "undecodable rows are reported", body: paris + "\n{\"id\": 2}\nnot json\n", wantIDs: []string{"1", "", ""}, wantErrors: []int{1, 2}},
+		{name: "broken array", body: "[" + paris + ",", wantErr: "invalid JSON array"},
+		{name: "empty", body: " \n", wantErr: "no destinations to import"},
+		{name: "empty array", body: "[]", wantErr: "no destinations to import"},
+		{name: "too many rows", body: strings.Repeat(paris+"\n", maxImportRows+1), wantErr: "exceed the limit"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			destinations, rowErrors, err := decodeImport([]byte(test.body))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(destinations) != len(test.wantIDs) {
+				t.Fatalf("decoded %d rows, want %d", len(destinations), len(test.wantIDs))
+			}
+			for i, id := range test.wantIDs {
+				if destinations[i].ID != id {
+					t.Errorf("row %d has id %q, want %q", i, destinations[i].ID, id)
+				}
+			}
+			if len(rowErrors) != len(test.wantErrors) {
+				t.Fatalf("row errors = %+v, want rows %v", rowErrors, test.wantErrors)
+			}
+			for i, index := range test.wantErrors {
+				if rowErrors[i].Index != index {
+					t.Errorf("row error %d is for row %d, want %d", i, rowErrors[i].Index, index)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateImport(t *testing.T) {
+	valid := func(id, city string) Destination {
+		return Destination{
+			ID:          id,
+			City:        city,
+			Country:     "France",
+			Latitude:    45,
+			Longitude:   5,
+			Population:  1000,
+			Description: city,
+			Images:      []string{},
+		}
+	}
+
+	unpopulated := valid("3", "Nice")
+	unpopulated.Population = 0
+
+	tests := []struct {
+		name         string
+		destinations []Destination
+		rowErrors    []ImportRowError
+		want         []string
+	}{
+		{name: "valid", destinations: []Destination{valid("1", "Paris"), valid("2", "Lyon")}},
+		{
+			name:         "rule issues are row errors",
+			destinations: []Destination{valid("1", "Paris"), valid("1", "Lyon")},
+			want:         []string{"1 1 duplicate-id"},
+		},
+		{
+			name:         "undecoded rows are only reported once, in row order",
+			destinations: []Destination{valid("1", "Paris"), {}, unpopulated},
+			rowErrors:    []ImportRowError{{Index: 1, Error: "cannot decode"}},
+			want:         []string{"1  cannot decode", "2 3 non-positive-population"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rowErrors := validateImport(test.destinations, test.rowErrors)
+			var got []string
+			for _, rowError := range rowErrors {
+				rule := strings.SplitN(rowError.Error, ":", 2)[0]
+				got = append(got, strings.Join([]string{strconv.Itoa(rowError.Index), rowError.ID, rule}, " "))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("row errors = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSameDestination(t *testing.T) {
+	base := Destination{ID: "1", City: "Paris", Country: "France", Population: 1, Images: []string{"a", "b"}}
+	change := func(f func(d *Destination)) Destination {
+		d := base
+		d.Images = append([]string{}, base.Images...)
+		f(&d)
+		return d
+	}
+
+	tests := []struct {
+		name  string
+		other Destination
+		want  bool
+	}{
+		{name: "identical", other: change(func(d *Destination) {}), want: true},
+		{name: "version is ignored", other: change(func(d *Destination) { d.Version = 7 }), want: true},
+		{name: "city", other: change(func(d *Destination) { d.City = "Lyon" })},
+		{name: "population", other: change(func(d *Destination) { d.Population = 2 })},
+		{name: "image order", other: change(func(d *Destination) { d.Images = []string{"b", "a"} })},
+		{name: "fewer images", other: change(func(d *Destination) { d.Images = d.Images[:1] })},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sameDestination(base, test.other); got != test.want {
+				t.Errorf("sameDestination() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
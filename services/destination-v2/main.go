@@ -1,31 +1,148 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
 	instana "github.com/instana/go-sensor"
+	"os"
 )
 
+const serviceName = "destination-v2"
+
 var lowercaseExceptions = []string{"es", "de", "au"}
 
 func main() {
-	if err := server.Start("destination-v2", initializeRouter); err != nil {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
+	options := make([]server.Option, 0)
+	if os.Getenv("SCHEMA_CHECK") == "true" {
+		options = append(options, server.WithStartupCheck(checkSchema))
+	}
+
+	init := initializeRouter
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "postgres":
+	case "memory":
+		publisher, err := newEventPublisher()
+		if err != nil {
+			panic(err)
+		}
+		repository, err := loadMemoryRepository(publisher)
+		if err != nil {
+			panic(err)
+		}
+		init = func(router server.PathRouter, _ database.Pool, _ *instana.Sensor) error {
+			initializeRoutes(router, repository, nil, nil)
+			return nil
+		}
+		options = append(options, server.WithoutDatabase())
+	default:
+		panic(fmt.Errorf("unknown STORAGE_BACKEND %q, expected postgres or memory", backend))
+	}
+
+	if err := server.Start(serviceName, init, options...); err != nil {
 		panic(err)
 	}
 }
 
-func initializeRouter(router server.PathRouter, pool database.Pool, _ *instana.Sensor) {
+func initializeRouter(router server.PathRouter, pool database.Pool, _ *instana.Sensor) error {
+	// Background work reacts to what was just written, so it can't read
+	// from a replica that may lag behind.
+	ctx := pgxpool.WithPrimary(context.Background())
+	if err := startEventRelay(ctx, pool); err != nil {
+		return err
+	}
+	feed := newChangeFeed(pool)
+	go feed.run(ctx)
+	go newDeliveryWorker(pool).run(ctx, feed)
+
+	initializeRoutes(router, newPostgresRepository(pool), pool, feed)
+	return nil
+}
+
+// initializeRoutes registers the API over repository. The change feed and
+// webhooks need the database, and are left out when pool is nil.
+func initializeRoutes(router server.PathRouter, repository Repository, pool database.Pool, feed *changeFeed) {
+	router.Path("/api/v1/destinations:import", func(router server.PathRouter) {
+		// path: /api/v1/destinations:import
+		router.Post(adminOnly(importDestinations(repository)))
+	})
+
+	if pool != nil {
+		initializeWebhookRoutes(router, pool)
+
+		router.Path("/api/v1/admin/database", func(router server.PathRouter) {
+			// path: /api/v1/admin/database
+			router.Get(adminOnly(databaseStats(pool)))
+		})
+	}
+
 	router.Path("/api/v1/destinations", func(router server.PathRouter) {
 		// path: /api/v1/destinations
-		router.Get(listDestinations(pool))
+		router.Get(listDestinations(repository))
+
+		if feed != nil {
+			router.Path("/changes", func(router server.PathRouter) {
+				// path: /api/v1/destinations/changes
+				router.Get(streamChanges(pool, feed))
+			})
+		}
 
 		router.Path("/{country:string}", func(router server.PathRouter) {
 			// path: /api/v1/destinations/:country
-			router.Get(listDestinationsByCountry(pool))
+			router.Get(listDestinationsByCountry(repository))
 
 			router.Path("/{city:string}", func(router server.PathRouter) {
 				// path: /api/v1/destinations/:country/:city
-				router.Get(listDestinationByCountryAndCity(pool))
+				router.Get(listDestinationByCountryAndCity(repository))
+				router.Put(requireAdmin(replaceDestination(repository)))
+				router.Patch(requireAdmin(patchDestination(repository)))
+				router.Delete(requireAdmin(deleteDestination(repository)))
+
+				router.Path("/history", func(router server.PathRouter) {
+					// path: /api/v1/destinations/:country/:city/history
+					router.Get(adminOnly(listDestinationHistory(repository)))
+				})
+
+				router.Path("/restore", func(router server.PathRouter) {
+					// path: /api/v1/destinations/:country/:city/restore
+					router.Post(adminOnly(restoreDestination(repository)))
+				})
+			})
+		})
+	})
+}
+
+func initializeWebhookRoutes(router server.PathRouter, pool database.Pool) {
+	router.Path("/api/v1/webhooks", func(router server.PathRouter) {
+		// path: /api/v1/webhooks
+		router.Get(adminOnly(listWebhooks(pool)))
+		router.Post(adminOnly(createWebhook(pool)))
+
+		router.Path("/{id:int64}", func(router server.PathRouter) {
+			// path: /api/v1/webhooks/:id
+			router.Get(adminOnly(getWebhook(pool)))
+			router.Patch(adminOnly(updateWebhook(pool)))
+			router.Delete(adminOnly(deleteWebhook(pool)))
+
+			router.Path("/deliveries", func(router server.PathRouter) {
+				// path: /api/v1/webhooks/:id/deliveries
+				router.Get(adminOnly(listWebhookDeliveries(pool)))
+			})
+
+			router.Path("/dead-letters", func(router server.PathRouter) {
+				// path: /api/v1/webhooks/:id/dead-letters
+				router.Get(adminOnly(listWebhookDeadLetters(pool)))
+
+				router.Path("/{letter:int64}/retry", func(router server.PathRouter) {
+					// path: /api/v1/webhooks/:id/dead-letters/:letter/retry
+					router.Post(adminOnly(retryWebhookDeadLetter(pool)))
+				})
 			})
 		})
 	})
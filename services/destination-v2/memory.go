@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/events"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"os"
+	"sync"
+	"time"
+)
+
+// memoryRepository keeps the destinations in memory, for development and CI
+// where no database is available. Changes are lost on restart and keep no
+// history, so History and as_of reads are unsupported. Events go straight
+// to the publisher instead of through the outbox, once the change is stored
+// and the lock released: a publisher that fails only loses the events, and a
+// slow one doesn't hold up the other requests.
+type memoryRepository struct {
+	mu           sync.RWMutex
+	destinations []Destination
+	index        map[string]int
+	publisher    events.Publisher
+}
+
+// loadMemoryRepository loads the file named by DESTINATIONS_FILE, in
+// destination-v1's destinations.json format.
+func loadMemoryRepository(publisher events.Publisher) (Repository, error) {
+	file := os.Getenv("DESTINATIONS_FILE")
+	if file == "" {
+		return nil, fmt.Errorf("DESTINATIONS_FILE must be set for the memory storage backend")
+	}
+	destinations, err := readDestinationsFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryRepository(destinations, publisher), nil
+}
+
+// newMemoryRepository stores destinations at version 1. Later entries
+// replace earlier ones with the same id. publisher may be nil.
+func newMemoryRepository(destinations []Destination, publisher events.Publisher) *memoryRepository {
+	r := &memoryRepository{
+		destinations: make([]Destination, 0, len(destinations)),
+		index:        make(map[string]int, len(destinations)),
+		publisher:    publisher,
+	}
+	for _, destination := range destinations {
+		destination.Version = 1
+		destination.DeletedAt = nil
+		r.store(destination)
+	}
+	return r
+}
+
+func (r *memoryRepository) Locations(_ server.RequestContext, country string, scope readScope) ([]Location, error) {
+	if scope.AsOf != nil {
+		return nil, errUnsupported
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	locations := make([]Location, 0)
+	for _, destination := range r.destinations {
+		if (destination.DeletedAt != nil && !scope.IncludeDeleted) || (country != "" && destination.Country != country) {
+			continue
+		}
+		locations = append(locations, Location{Country: destination.Country, City: destination.City})
+	}
+	return locations, nil
+}
+
+func (r *memoryRepository) Destinations(_ server.RequestContext, country, city string, scope readScope) ([]Destination, error) {
+	if scope.AsOf != nil {
+		return nil, errUnsupported
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	destinations := make([]Destination, 0)
+	for _, destination := range r.destinations {
+		if (destination.DeletedAt != nil && !scope.IncludeDeleted) || destination.Country != country || destination.City != city {
+			continue
+		}
+		destinations = append(destinations, cloneDestination(destination))
+	}
+	return destinations, nil
+}
+
+func (r *memoryRepository) DestinationsByID(_ server.RequestContext, ids []string) (map[string]Destination, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	destinations := make(map[string]Destination)
+	for _, id := range ids {
+		if i, ok := r.index[id]; ok {
+			destinations[id] = cloneDestination(r.destinations[i])
+		}
+	}
+	return destinations, nil
+}
+
+func (r *memoryRepository) History(server.RequestContext, string, string) ([]HistoryEntry, error) {
+	return nil, errUnsupported
+}
+
+// Update follows the database: the version only moves when the content
+// changes, and deleted destinations can't be updated.
+func (r *memoryRepository) Update(ctx server.RequestContext, _ Audit, previous, d Destination) (Destination, error) {
+	r.mu.Lock()
+	current, ok := r.find(d.ID)
+	if !ok || current.Version != previous.Version || current.DeletedAt != nil {
+		r.mu.Unlock()
+		return d, errVersionConflict
+	}
+	d.Version = current.Version
+	d.DeletedAt = nil
+	if !sameDestination(current, d) {
+		d.Version++
+	}
+
+	evs, err := destinationEvents(destinationChange{
+		Type:        eventDestinationUpdated,
+		ID:          d.ID,
+		Version:     d.Version,
+		Destination: &d,
+		Previous:    &previous,
+	})
+	if err != nil {
+		r.mu.Unlock()
+		return d, err
+	}
+	r.store(d)
+	r.mu.Unlock()
+
+	r.publish(ctx, evs)
+	return d, nil
+}
+
+func (r *memoryRepository) SetDeleted(ctx server.RequestContext, _ Audit, d Destination, deleted bool) (Destination, error) {
+	r.mu.Lock()
+	previous := d
+	current, ok := r.find(d.ID)
+	if !ok || current.Version != previous.Version || (current.DeletedAt != nil) == deleted {
+		r.mu.Unlock()
+		return d, errVersionConflict
+	}
+	d = current
+	d.Version++
+	d.DeletedAt = nil
+	if deleted {
+		now := time.Now().UTC()
+		d.DeletedAt = &now
+	}
+
+	evs, err := destinationEvents(deletionChange(previous, d, deleted))
+	if err != nil {
+		r.mu.Unlock()
+		return previous, err
+	}
+	r.store(d)
+	r.mu.Unlock()
+
+	r.publish(ctx, evs)
+	return d, nil
+}
+
+// Import writes the destinations that are new, changed or deleted, like the
+// upsert in sql.go.
+func (r *memoryRepository) Import(ctx server.RequestContext, _ Audit, destinations []Destination, existing map[string]Destination) ([]upserted, error) {
+	r.mu.Lock()
+	written := make([]upserted, 0, len(destinations))
+	stored := make([]Destination, 0, len(destinations))
+	for _, destination := range destinations {
+		destination.Version = 1
+		destination.DeletedAt = nil
+		current, ok := r.find(destination.ID)
+		if ok {
+			if sameDestination(current, destination) && current.DeletedAt == nil {
+				continue
+			}
+			destination.Version = current.Version + 1
+		}
+		written = append(written, upserted{ID: destination.ID, Version: destination.Version, Inserted: !ok})
+		stored = append(stored, destination)
+	}
+
+	evs, err := destinationEvents(importChanges(destinations, existing, written)...)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	for _, destination := range stored {
+		r.store(destination)
+	}
+	r.mu.Unlock()
+
+	r.publish(ctx, evs)
+	return written, nil
+}
+
+// find returns a copy of the destination with id. The caller holds mu.
+func (r *memoryRepository) find(id string) (Destination, bool) {
+	i, ok := r.index[id]
+	if !ok {
+		return Destination{}, false
+	}
+	return cloneDestination(r.destinations[i]), true
+}
+
+// store adds d or replaces the destination with its id. The caller holds
+// mu, or is the constructor.
+func (r *memoryRepository) store(d Destination) {
+	d = cloneDestination(d)
+	if d.Images == nil {
+		d.Images = make([]string, 0)
+	}
+	if i, ok := r.index[d.ID]; ok {
+		r.destinations[i] = d
+		return
+	}
+	r.index[d.ID] = len(r.destinations)
+	r.destinations = append(r.destinations, d)
+}
+
+// publish sends the events of a stored change. The caller doesn't hold mu.
+// The change is made by then, so a failure is only logged.
+func (r *memoryRepository) publish(ctx server.RequestContext, evs []events.Event) {
+	if r.publisher == nil || len(evs) == 0 {
+		return
+	}
+	if err := r.publisher.Publish(ctx.Request().Context(), evs...); err != nil {
+		fmt.Printf("couldn't publish %d destination events: %v\n", len(evs), err)
+	}
+}
+
+// cloneDestination copies d so that callers can't modify the stored images.
+func cloneDestination(d Destination) Destination {
+	if d.Images != nil {
+		d.Images = append(make([]string, 0, len(d.Images)), d.Images...)
+	}
+	if d.DeletedAt != nil {
+		deletedAt := *d.DeletedAt
+		d.DeletedAt = &deletedAt
+	}
+	return d
+}
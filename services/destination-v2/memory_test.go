@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/events"
+	"testing"
+	"time"
+)
+
+// readingPublisher reads the repository while publishing, which deadlocks if
+// the repository publishes under its lock.
+type readingPublisher struct {
+	repository *memoryRepository
+	err        error
+	published  []events.Event
+}
+
+func (p *readingPublisher) Publish(_ context.Context, evs ...events.Event) error {
+	if _, err := p.repository.Locations(newRequestContext(nil), "", readScope{}); err != nil {
+		return err
+	}
+	p.published = append(p.published, evs...)
+	return p.err
+}
+
+func TestMemoryRepositoryPublishesAfterUnlocking(t *testing.T) {
+	paris := Destination{ID: "1", City: "Paris", Country: "France", Images: []string{}}
+	rome := Destination{ID: "2", City: "Rome", Country: "Italy", Images: []string{}}
+
+	tests := []struct {
+		name       string
+		publishErr error
+		write      func(r *memoryRepository) error
+		wantType   string
+	}{
+		{
+			name: "update",
+			write: func(r *memoryRepository) error {
+				current, _ := r.find("1")
+				updated := current
+				updated.Population = 2000000
+				_, err := r.Update(newRequestContext(nil), Audit{}, current, updated)
+				return err
+			},
+			wantType: eventDestinationUpdated,
+		},
+		{
+			name: "delete",
+			write: func(r *memoryRepository) error {
+				current, _ := r.find("1")
+				_, err := r.SetDeleted(newRequestContext(nil), Audit{}, current, true)
+				return err
+			},
+			wantType: eventDestinationDeleted,
+		},
+		{
+			name: "import",
+			write: func(r *memoryRepository) error {
+				existing, _ := r.DestinationsByID(newRequestContext(nil), []string{"2"})
+				_, err := r.Import(newRequestContext(nil), Audit{}, []Destination{rome}, existing)
+				return err
+			},
+			wantType: eventDestinationCreated,
+		},
+		{
+			name:       "failing publisher",
+			publishErr: fmt.Errorf("publisher unavailable"),
+			write: func(r *memoryRepository) error {
+				current, _ := r.find("1")
+				_, err := r.SetDeleted(newRequestContext(nil), Audit{}, current, true)
+				return err
+			},
+			wantType: eventDestinationDeleted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			publisher := &readingPublisher{err: test.publishErr}
+			repository := newMemoryRepository([]Destination{paris}, publisher)
+			publisher.repository = repository
+
+			done := make(chan error, 1)
+			go func() { done <- test.write(repository) }()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("write failed: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("write deadlocked with a publisher that reads the repository")
+			}
+
+			if len(publisher.published) != 1 || publisher.published[0].Type != test.wantType {
+				t.Errorf("published %+v, want one %s event", publisher.published, test.wantType)
+			}
+		})
+	}
+}
+
+func TestMemoryRepositoryKeepsChangesWhenPublishingFails(t *testing.T) {
+	paris := Destination{ID: "1", City: "Paris", Country: "France"}
+	publisher := &readingPublisher{err: fmt.Errorf("publisher unavailable")}
+	repository := newMemoryRepository([]Destination{paris}, publisher)
+	publisher.repository = repository
+
+	current, _ := repository.find("1")
+	updated := current
+	updated.Population = 2000000
+	if _, err := repository.Update(newRequestContext(nil), Audit{}, current, updated); err != nil {
+		t.Fatalf("Update() error = %v, want the change kept", err)
+	}
+
+	stored, _ := repository.find("1")
+	if stored.Population != 2000000 || stored.Version != 2 {
+		t.Errorf("stored %+v, want the update at version 2", stored)
+	}
+}
@@ -0,0 +1,14 @@
+-- Environments populated by the data generator already have this table, so
+-- the baseline only creates what is missing.
+CREATE TABLE IF NOT EXISTS destination (
+    id          text PRIMARY KEY,
+    city        text NOT NULL,
+    country     text NOT NULL,
+    latitude    double precision NOT NULL,
+    longitude   double precision NOT NULL,
+    population  integer NOT NULL,
+    description text NOT NULL,
+    images      text[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS destination_country_city_idx ON destination (country, city);
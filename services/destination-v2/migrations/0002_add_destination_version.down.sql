@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS destination_bump_version ON destination;
+DROP FUNCTION IF EXISTS destination_bump_version();
+ALTER TABLE destination DROP COLUMN IF EXISTS version;
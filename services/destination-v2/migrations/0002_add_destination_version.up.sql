@@ -0,0 +1,16 @@
+-- version is the row's revision and the basis of its ETag. The trigger bumps
+-- it on every real change, however the row is written.
+ALTER TABLE destination ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION destination_bump_version() RETURNS trigger AS $$
+BEGIN
+    IF ROW(NEW.*) IS DISTINCT FROM ROW(OLD.*) THEN
+        NEW.version := OLD.version + 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER destination_bump_version
+    BEFORE UPDATE ON destination
+    FOR EACH ROW EXECUTE FUNCTION destination_bump_version();
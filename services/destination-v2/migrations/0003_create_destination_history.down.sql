@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS destination_record_history_update ON destination;
+DROP TRIGGER IF EXISTS destination_record_history ON destination;
+DROP FUNCTION IF EXISTS destination_record_history();
+DROP TABLE IF EXISTS destination_history;
@@ -0,0 +1,45 @@
+-- destination_history records every change to a destination, however it is
+-- written. Writers name themselves with set_config('app.actor', ..., true)
+-- and give a reason with app.reason; changes made without them are
+-- attributed to the database user.
+CREATE TABLE IF NOT EXISTS destination_history (
+    id             bigserial PRIMARY KEY,
+    destination_id text NOT NULL,
+    operation      text NOT NULL,
+    actor          text NOT NULL,
+    reason         text,
+    changed_at     timestamptz NOT NULL DEFAULT now(),
+    before         jsonb,
+    after          jsonb
+);
+
+CREATE INDEX IF NOT EXISTS destination_history_destination_id_idx ON destination_history (destination_id, changed_at);
+CREATE INDEX IF NOT EXISTS destination_history_changed_at_idx ON destination_history (changed_at);
+
+CREATE OR REPLACE FUNCTION destination_record_history() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO destination_history (destination_id, operation, actor, reason, before, after)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.id ELSE NEW.id END,
+        TG_OP,
+        COALESCE(NULLIF(current_setting('app.actor', true), ''), current_user),
+        NULLIF(current_setting('app.reason', true), ''),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER destination_record_history
+    AFTER INSERT OR DELETE ON destination
+    FOR EACH ROW EXECUTE FUNCTION destination_record_history();
+
+CREATE TRIGGER destination_record_history_update
+    AFTER UPDATE ON destination
+    FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION destination_record_history();
+
+-- Existing destinations start their history here; as_of queries can't see
+-- further back than this migration.
+INSERT INTO destination_history (destination_id, operation, actor, reason, after)
+SELECT id, 'INSERT', 'migration', 'history backfill', to_jsonb(destination) FROM destination;
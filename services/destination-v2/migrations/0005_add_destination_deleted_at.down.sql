@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS destination_deleted_at_idx;
+ALTER TABLE destination DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+-- Deleted destinations keep their row, so that the ids bookings refer to
+-- still resolve, until the purge command removes them.
+ALTER TABLE destination ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS destination_deleted_at_idx ON destination (deleted_at) WHERE deleted_at IS NOT NULL;
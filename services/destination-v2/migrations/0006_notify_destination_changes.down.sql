@@ -0,0 +1,15 @@
+CREATE OR REPLACE FUNCTION destination_record_history() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO destination_history (destination_id, operation, actor, claimed_actor, reason, before, after)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.id ELSE NEW.id END,
+        TG_OP,
+        COALESCE(NULLIF(current_setting('app.actor', true), ''), current_user),
+        NULLIF(current_setting('app.claimed_actor', true), ''),
+        NULLIF(current_setting('app.reason', true), ''),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,23 @@
+-- Every history entry is announced on the destination_changes channel with
+-- its id as payload; listeners read the entry itself from the table, since
+-- a full row may not fit in a notification.
+CREATE OR REPLACE FUNCTION destination_record_history() RETURNS trigger AS $$
+DECLARE
+    history_id bigint;
+BEGIN
+    INSERT INTO destination_history (destination_id, operation, actor, claimed_actor, reason, before, after)
+    VALUES (
+        CASE WHEN TG_OP = 'DELETE' THEN OLD.id ELSE NEW.id END,
+        TG_OP,
+        COALESCE(NULLIF(current_setting('app.actor', true), ''), current_user),
+        NULLIF(current_setting('app.claimed_actor', true), ''),
+        NULLIF(current_setting('app.reason', true), ''),
+        CASE WHEN TG_OP = 'INSERT' THEN NULL ELSE to_jsonb(OLD) END,
+        CASE WHEN TG_OP = 'DELETE' THEN NULL ELSE to_jsonb(NEW) END
+    )
+    RETURNING id INTO history_id;
+
+    PERFORM pg_notify('destination_changes', history_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS destination_history_transaction_id_idx;
+ALTER TABLE destination_history DROP COLUMN IF EXISTS transaction_id;
@@ -0,0 +1,9 @@
+-- Transactions can commit in another order than the one they took history
+-- ids in, so the change feed can't resume from an id: an entry committed
+-- after a later id was read would be skipped. It reads entries in the order
+-- of the transaction that wrote them instead, and only those of transactions
+-- older than every transaction still in progress, which can't gain entries
+-- anymore. xid8 needs PostgreSQL 13.
+ALTER TABLE destination_history ADD COLUMN IF NOT EXISTS transaction_id xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS destination_history_transaction_id_idx ON destination_history (transaction_id, id);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS destination_queue_webhooks ON destination_history;
+DROP FUNCTION IF EXISTS destination_queue_webhooks();
+DROP TABLE IF EXISTS webhook_dead_letter;
+DROP TABLE IF EXISTS webhook_delivery;
+DROP TABLE IF EXISTS webhook_subscription;
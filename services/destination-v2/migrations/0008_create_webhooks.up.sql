@@ -0,0 +1,76 @@
+-- Partners subscribe to destination changes with webhooks. Deliveries are
+-- queued by a trigger on destination_history, in the transaction that made
+-- the change, and sent by the service's delivery worker.
+CREATE TABLE IF NOT EXISTS webhook_subscription (
+    id         bigserial PRIMARY KEY,
+    url        text NOT NULL,
+    secret     text NOT NULL,
+    -- Empty filters match everything; countries are stored in lower case.
+    events     text[] NOT NULL DEFAULT '{}',
+    countries  text[] NOT NULL DEFAULT '{}',
+    active     boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id              bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL REFERENCES webhook_subscription (id) ON DELETE CASCADE,
+    history_id      bigint NOT NULL REFERENCES destination_history (id) ON DELETE CASCADE,
+    event_type      text NOT NULL,
+    status          text NOT NULL DEFAULT 'pending',
+    attempts        integer NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_attempt_at timestamptz,
+    last_status     integer,
+    last_error      text,
+    created_at      timestamptz NOT NULL DEFAULT now(),
+    delivered_at    timestamptz,
+    UNIQUE (subscription_id, history_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+
+-- Deliveries that exhausted their attempts are moved here until they are
+-- retried by hand.
+CREATE TABLE IF NOT EXISTS webhook_dead_letter (
+    id              bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL REFERENCES webhook_subscription (id) ON DELETE CASCADE,
+    history_id      bigint NOT NULL REFERENCES destination_history (id) ON DELETE CASCADE,
+    event_type      text NOT NULL,
+    attempts        integer NOT NULL,
+    last_status     integer,
+    last_error      text,
+    created_at      timestamptz NOT NULL,
+    failed_at       timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_dead_letter_subscription_id_idx ON webhook_dead_letter (subscription_id);
+
+-- The event types mirror the change feed's: soft deletes and restores are
+-- deletes and creates to subscribers.
+CREATE OR REPLACE FUNCTION destination_queue_webhooks() RETURNS trigger AS $$
+DECLARE
+    event_type text;
+BEGIN
+    event_type := CASE
+        WHEN NEW.before IS NULL THEN 'create'
+        WHEN NEW.after IS NULL THEN 'delete'
+        WHEN NEW.after->>'deleted_at' IS NOT NULL AND NEW.before->>'deleted_at' IS NULL THEN 'delete'
+        WHEN NEW.after->>'deleted_at' IS NULL AND NEW.before->>'deleted_at' IS NOT NULL THEN 'create'
+        ELSE 'update'
+    END;
+
+    INSERT INTO webhook_delivery (subscription_id, history_id, event_type)
+    SELECT s.id, NEW.id, event_type
+    FROM webhook_subscription s
+    WHERE s.active
+      AND (cardinality(s.events) = 0 OR event_type = ANY (s.events))
+      AND (cardinality(s.countries) = 0 OR lower(COALESCE(NEW.after, NEW.before)->>'country') = ANY (s.countries));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER destination_queue_webhooks
+    AFTER INSERT ON destination_history
+    FOR EACH ROW EXECUTE FUNCTION destination_queue_webhooks();
@@ -0,0 +1,14 @@
+-- Domain events are written here in the transaction of the change they
+-- describe, and relayed to the event publisher afterwards.
+CREATE TABLE IF NOT EXISTS outbox_event (
+    id           bigserial PRIMARY KEY,
+    event_id     uuid NOT NULL UNIQUE,
+    type         text NOT NULL,
+    version      integer NOT NULL,
+    subject      text NOT NULL,
+    payload      jsonb NOT NULL,
+    occurred_at  timestamptz NOT NULL,
+    published_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS outbox_event_unpublished_idx ON outbox_event (id) WHERE published_at IS NULL;
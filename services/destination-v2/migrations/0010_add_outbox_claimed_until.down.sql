@@ -0,0 +1 @@
+ALTER TABLE outbox_event DROP COLUMN IF EXISTS claimed_until;
@@ -0,0 +1,4 @@
+-- A relay leases the events it publishes until claimed_until, so that it can
+-- commit the claim and publish outside of a transaction. Other relays skip
+-- leased events until the lease ends.
+ALTER TABLE outbox_event ADD COLUMN IF NOT EXISTS claimed_until timestamptz;
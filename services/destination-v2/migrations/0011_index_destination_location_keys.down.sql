@@ -0,0 +1 @@
+DROP INDEX IF EXISTS destination_location_key_idx;
@@ -0,0 +1,7 @@
+-- destination-v1's postgres provider looks destinations up by their names in
+-- URL form, lower case with dashes for spaces. These indexes are on the same
+-- expressions and condition as its queries, which can't use an index on the
+-- plain columns.
+CREATE INDEX IF NOT EXISTS destination_location_key_idx
+    ON destination ((replace(lower(country), ' ', '-')), (replace(lower(city), ' ', '-')))
+    WHERE deleted_at IS NULL;
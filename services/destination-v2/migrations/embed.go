@@ -0,0 +1,10 @@
+// Package migrations holds the destination-v2 schema as numbered pairs of
+// <version>_<name>.up.sql and <version>_<name>.down.sql files. The 0001
+// baseline has no down migration: the destination table it describes may
+// predate the migrations, and reverting it would drop that data.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
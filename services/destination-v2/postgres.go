@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+)
+
+// postgresRepository is the Repository in production. Its writes run in a
+// transaction that also records the history and fills the outbox.
+type postgresRepository struct {
+	pool database.Pool
+}
+
+func newPostgresRepository(pool database.Pool) Repository {
+	return postgresRepository{pool: pool}
+}
+
+func (r postgresRepository) Locations(ctx server.RequestContext, country string, scope readScope) ([]Location, error) {
+	return queryLocations(r.pool, ctx, country, scope)
+}
+
+func (r postgresRepository) Destinations(ctx server.RequestContext, country, city string, scope readScope) ([]Destination, error) {
+	return queryDestinations(r.pool, ctx, country, city, scope)
+}
+
+func (r postgresRepository) DestinationsByID(ctx server.RequestContext, ids []string) (map[string]Destination, error) {
+	return queryDestinationsByID(r.pool, ctx, ids)
+}
+
+func (r postgresRepository) History(ctx server.RequestContext, country, city string) ([]HistoryEntry, error) {
+	return queryHistory(r.pool, ctx, country, city)
+}
+
+func (r postgresRepository) Update(ctx server.RequestContext, audit Audit, previous, d Destination) (Destination, error) {
+	err := database.WithTx(r.pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+		if err := audit.record(tx, ctx.Request().Context()); err != nil {
+			return err
+		}
+		var err error
+		d.Version, err = updateDestination(tx, ctx.Request().Context(), d, previous.Version)
+		if err != nil {
+			return err
+		}
+		return publishChanges(tx, ctx.Request().Context(), destinationChange{
+			Type:        eventDestinationUpdated,
+			ID:          d.ID,
+			Version:     d.Version,
+			Destination: &d,
+			Previous:    &previous,
+		})
+	})
+	return d, err
+}
+
+func (r postgresRepository) SetDeleted(ctx server.RequestContext, audit Audit, d Destination, deleted bool) (Destination, error) {
+	previous := d
+	err := database.WithTx(r.pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+		if err := audit.record(tx, ctx.Request().Context()); err != nil {
+			return err
+		}
+		var err error
+		d.DeletedAt, d.Version, err = setDestinationDeleted(tx, ctx.Request().Context(), previous.ID, previous.Version, deleted)
+		if err != nil {
+			return err
+		}
+		return publishChanges(tx, ctx.Request().Context(), deletionChange(previous, d, deleted))
+	})
+	return d, err
+}
+
+func (r postgresRepository) Import(ctx server.RequestContext, audit Audit, destinations []Destination, existing map[string]Destination) ([]upserted, error) {
+	var written []upserted
+	err := database.WithTx(r.pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+		if err := audit.record(tx, ctx.Request().Context()); err != nil {
+			return err
+		}
+		var err error
+		written, err = upsertDestinations(tx, ctx.Request().Context(), destinations)
+		if err != nil {
+			return err
+		}
+		return publishChanges(tx, ctx.Request().Context(), importChanges(destinations, existing, written)...)
+	})
+	return written, err
+}
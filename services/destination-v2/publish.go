@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/events"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"os"
+	"time"
+)
+
+// destinationEventVersion is the version of DestinationEventData. Bump it,
+// and keep consumers informed, on any incompatible change.
+const destinationEventVersion = 1
+
+const (
+	eventDestinationCreated  = "destination.created"
+	eventDestinationUpdated  = "destination.updated"
+	eventDestinationDeleted  = "destination.deleted"
+	eventDestinationRestored = "destination.restored"
+	eventDestinationPurged   = "destination.purged"
+)
+
+const relayInterval = time.Second
+
+// DestinationEventData is the data of destination events. Destination is
+// the state after the change and Previous the state before it, when they
+// are known; purged destinations have neither.
+type DestinationEventData struct {
+	ID          string       `json:"id"`
+	Version     int          `json:"version,omitempty"`
+	Destination *Destination `json:"destination,omitempty"`
+	Previous    *Destination `json:"previous,omitempty"`
+}
+
+type destinationChange struct {
+	Type        string
+	ID          string
+	Version     int
+	Destination *Destination
+	Previous    *Destination
+}
+
+// publishChanges writes the events for changes to the outbox, in the
+// transaction that makes them.
+func publishChanges(tx pgxpool.Tx, ctx context.Context, changes ...destinationChange) error {
+	evs, err := destinationEvents(changes...)
+	if err != nil {
+		return err
+	}
+	return events.NewOutbox(tx).Publish(ctx, evs...)
+}
+
+func destinationEvents(changes ...destinationChange) ([]events.Event, error) {
+	evs := make([]events.Event, 0, len(changes))
+	for _, change := range changes {
+		event, err := events.New(change.Type, destinationEventVersion, change.ID, DestinationEventData{
+			ID:          change.ID,
+			Version:     change.Version,
+			Destination: change.Destination,
+			Previous:    change.Previous,
+		})
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, event)
+	}
+	return evs, nil
+}
+
+// deletionChange describes d being soft-deleted or restored.
+func deletionChange(previous, d Destination, deleted bool) destinationChange {
+	change := destinationChange{Type: eventDestinationRestored, ID: d.ID, Version: d.Version, Destination: &d, Previous: &previous}
+	if deleted {
+		change.Type = eventDestinationDeleted
+	}
+	return change
+}
+
+// newEventPublisher creates the publisher that the outbox is relayed to,
+// named by EVENT_PUBLISHER: nats, memory or none, the default. Without a
+// publisher events stay in the outbox until one is configured.
+func newEventPublisher() (events.Publisher, error) {
+	switch name := os.Getenv("EVENT_PUBLISHER"); name {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return events.NewMemory(events.DefaultMemoryLimit), nil
+	case "nats":
+		url := os.Getenv("NATS_URL")
+		if url == "" {
+			url = "nats://localhost:4222"
+		}
+		prefix := os.Getenv("NATS_SUBJECT_PREFIX")
+		if prefix == "" {
+			prefix = "beetravels"
+		}
+		return events.NewNATS(url, prefix, serviceName)
+	default:
+		return nil, fmt.Errorf("unknown EVENT_PUBLISHER %q, expected nats, memory or none", name)
+	}
+}
+
+// startEventRelay relays the outbox to the configured publisher in the
+// background.
+func startEventRelay(ctx context.Context, pool database.Pool) error {
+	publisher, err := newEventPublisher()
+	if err != nil || publisher == nil {
+		return err
+	}
+	go events.NewRelay(pool, publisher).Run(ctx, relayInterval)
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/events"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"os"
+	"time"
+)
+
+const (
+	defaultRetention = 90 * 24 * time.Hour
+	// defaultOutboxRetention keeps published events long enough to look
+	// into a consumer's complaint, not as an event store.
+	defaultOutboxRetention = 7 * 24 * time.Hour
+)
+
+type PurgeResult struct {
+	DryRun bool      `json:"dryRun"`
+	Before time.Time `json:"before"`
+	Purged []string  `json:"purged"`
+	// OutboxPruned counts the events published before OutboxBefore that
+	// were removed from the outbox, and OutboxUnpublishedPruned those that
+	// occurred before it and were never published.
+	OutboxBefore            time.Time `json:"outboxBefore"`
+	OutboxPruned            int64     `json:"outboxPruned"`
+	OutboxUnpublishedPruned int64     `json:"outboxUnpublishedPruned"`
+}
+
+// purgeCommand permanently deletes the destinations that were soft-deleted
+// longer ago than the retention period. It is meant to run as a scheduled
+// job.
+func purgeCommand(args []string) int {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	retention := flags.Duration("retention", defaultRetention, "how long soft-deleted destinations are kept (env DELETED_RETENTION)")
+	outboxRetention := flags.Duration("outbox-retention", defaultOutboxRetention, "how long published events are kept in the outbox (env OUTBOX_RETENTION)")
+	dryRun := flags.Bool("dry-run", false, "only list the destinations that would be purged")
+	for name, env := range map[string]string{"retention": "DELETED_RETENTION", "outbox-retention": "OUTBOX_RETENTION"} {
+		if value, ok := os.LookupEnv(env); ok {
+			if err := flags.Set(name, value); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid %s %q\n", env, value)
+				return 2
+			}
+		}
+	}
+	flags.Parse(args)
+
+	if *retention <= 0 {
+		fmt.Fprintln(os.Stderr, "purge: -retention must be positive")
+		return 2
+	}
+	if *outboxRetention <= 0 {
+		fmt.Fprintln(os.Stderr, "purge: -outbox-retention must be positive")
+		return 2
+	}
+
+	pool, err := connect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	now := time.Now().UTC()
+	result := PurgeResult{DryRun: *dryRun, Before: now.Add(-*retention), OutboxBefore: now.Add(-*outboxRetention)}
+	audit := Audit{Actor: "purge", Reason: fmt.Sprintf("deleted before %s", result.Before.Format(time.RFC3339))}
+	err = database.WithTxContext(pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+		if err := audit.record(tx, ctx); err != nil {
+			return err
+		}
+		var err error
+		result.Purged, err = purgeDestinations(tx, ctx, result.Before, *dryRun)
+		if err != nil || *dryRun {
+			return err
+		}
+		changes := make([]destinationChange, len(result.Purged))
+		for i, id := range result.Purged {
+			changes[i] = destinationChange{Type: eventDestinationPurged, ID: id}
+		}
+		return publishChanges(tx, ctx, changes...)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if !*dryRun {
+		pruned, err := events.Prune(ctx, pool, result.OutboxBefore)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		result.OutboxPruned, result.OutboxUnpublishedPruned = pruned.Published, pruned.Unpublished
+		if pruned.Unpublished > 0 {
+			fmt.Fprintf(os.Stderr, "purge: removed %d events that were never published\n", pruned.Unpublished)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+	return 0
+}
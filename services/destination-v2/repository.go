@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+)
+
+// errUnsupported is returned by backends for the reads they can't serve.
+var errUnsupported = errors.New("not supported by this storage backend")
+
+// Repository stores destinations for the API. Writes record their audit and
+// publish the matching domain events together with the change.
+type Repository interface {
+	Locations(ctx server.RequestContext, country string, scope readScope) ([]Location, error)
+	Destinations(ctx server.RequestContext, country, city string, scope readScope) ([]Destination, error)
+	// DestinationsByID returns the destinations among ids, by id, including
+	// soft-deleted ones.
+	DestinationsByID(ctx server.RequestContext, ids []string) (map[string]Destination, error)
+	History(ctx server.RequestContext, country, city string) ([]HistoryEntry, error)
+
+	// Update replaces the content of previous with d, and returns it with its
+	// new version. errVersionConflict means previous is out of date.
+	Update(ctx server.RequestContext, audit Audit, previous, d Destination) (Destination, error)
+	// SetDeleted soft-deletes or restores d, and returns it updated.
+	// errVersionConflict means d is out of date.
+	SetDeleted(ctx server.RequestContext, audit Audit, d Destination, deleted bool) (Destination, error)
+	// Import upserts destinations by id and returns the rows it changed;
+	// existing holds their state beforehand.
+	Import(ctx server.RequestContext, audit Audit, destinations []Destination, existing map[string]Destination) ([]upserted, error)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/bee-travels/bee-travels-go/services/shared/validation"
+	"github.com/elgris/sqrl"
+	"github.com/jackc/pgx/v4"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const seedTable = "destination_seed"
+
+type SeedResult struct {
+	Inserted int   `json:"inserted"`
+	Updated  int   `json:"updated"`
+	Deleted  int64 `json:"deleted"`
+}
+
+// seedCommand loads a file in destination-v1's destinations.json format into
+// the destination table, upserting by id.
+func seedCommand(args []string) int {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	file := flags.String("file", "", "destinations.json file to load")
+	prune := flags.Bool("prune", false, "soft-delete destinations that are missing from the file")
+	force := flags.Bool("force", false, "let -prune soft-delete every destination when the file is empty")
+	actor := flags.String("actor", "seed", "actor recorded in the destination history")
+	reason := flags.String("reason", "", "reason recorded in the destination history")
+	flags.Parse(args)
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "seed: -file is required")
+		flags.Usage()
+		return 2
+	}
+
+	destinations, err := readDestinationsFile(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	report, err := checkSeed(*file, destinations, *prune, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !report.Valid {
+		fmt.Fprintf(os.Stderr, "seed: %s has %d issues, nothing was written\n", *file, len(report.Issues))
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return 1
+	}
+
+	pool, err := connect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	result, err := seedDestinations(pool, ctx, destinations, *prune, Audit{Actor: *actor, Reason: *reason})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+	return 0
+}
+
+// checkSeed applies the rules of the validate command to the destinations of
+// file. It refuses to prune with an empty file, which would soft-delete the
+// whole catalogue, unless forced.
+func checkSeed(file string, destinations []Destination, prune, force bool) (*validation.Report, error) {
+	if prune && len(destinations) == 0 && !force {
+		return nil, fmt.Errorf("seed: %s has no destinations, and -prune would delete them all; add -force to do it", file)
+	}
+	report := validation.NewReport(file)
+	checkDestinations(report, destinations)
+	return report, nil
+}
+
+// seedDestinations copies destinations into a temporary table and merges it
+// into the destination table in a single transaction. Rows whose content is
+// unchanged are left alone and not counted.
+func seedDestinations(pool database.Pool, ctx context.Context, destinations []Destination, prune bool, audit Audit) (SeedResult, error) {
+	rows, byID := seedRows(destinations)
+
+	var result SeedResult
+	err := database.WithTxContext(pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+		result = SeedResult{}
+
+		if err := audit.record(tx, ctx); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (LIKE destination INCLUDING DEFAULTS) ON COMMIT DROP", seedTable))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Conn().CopyFrom(ctx, pgx.Identifier{seedTable}, destinationColumns, pgx.CopyFromRows(rows))
+		if err != nil {
+			return err
+		}
+
+		upsert := database.QueryBuilder().
+			Insert("destination").
+			Columns(destinationColumns...).
+			Select(database.QueryBuilder().Select(destinationColumns...).From(seedTable)).
+			Suffix(upsertSuffix)
+
+		sql, args, err := upsert.ToSql()
+		if err != nil {
+			return err
+		}
+		written, err := readUpserts(tx.Query(ctx, sql, args...))
+		if err != nil {
+			return err
+		}
+		result.Inserted, result.Updated = countUpserts(written)
+
+		changes := make([]destinationChange, 0, len(written))
+		for _, u := range written {
+			destination := byID[u.ID]
+			destination.Version = u.Version
+			change := destinationChange{Type: eventDestinationCreated, ID: u.ID, Version: u.Version, Destination: &destination}
+			if !u.Inserted {
+				change.Type = eventDestinationUpdated
+			}
+			changes = append(changes, change)
+		}
+		if !prune {
+			return publishChanges(tx, ctx, changes...)
+		}
+
+		sql, args, err = database.QueryBuilder().
+			Update("destination").
+			Set("deleted_at", sqrl.Expr("now()")).
+			Where("deleted_at IS NULL").
+			Where(fmt.Sprintf("id NOT IN (SELECT id FROM %s)", seedTable)).
+			Suffix("RETURNING id, version").
+			ToSql()
+		if err != nil {
+			return err
+		}
+		deleted, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return err
+		}
+		defer deleted.Close()
+		for deleted.Next() {
+			change := destinationChange{Type: eventDestinationDeleted}
+			if err := deleted.Scan(&change.ID, &change.Version); err != nil {
+				return err
+			}
+			changes = append(changes, change)
+			result.Deleted++
+		}
+		if err := deleted.Err(); err != nil {
+			return err
+		}
+		return publishChanges(tx, ctx, changes...)
+	})
+	return result, err
+}
+
+// seedRows turns destinations into the rows copied into the seed table.
+// Postgres refuses to upsert the same row twice in one statement, so a
+// repeated id keeps its last definition, as in destination-v1.
+func seedRows(destinations []Destination) ([][]interface{}, map[string]Destination) {
+	index := make(map[string]int, len(destinations))
+	byID := make(map[string]Destination, len(destinations))
+	rows := make([][]interface{}, 0, len(destinations))
+	for _, d := range destinations {
+		// The images column is NOT NULL, and so is the one of the copy.
+		if d.Images == nil {
+			d.Images = make([]string, 0)
+		}
+		row := []interface{}{d.ID, d.City, d.Country, d.Latitude, d.Longitude, d.Population, d.Description, d.Images}
+		byID[d.ID] = d
+		if i, ok := index[d.ID]; ok {
+			rows[i] = row
+			continue
+		}
+		index[d.ID] = len(rows)
+		rows = append(rows, row)
+	}
+	return rows, byID
+}
+
+// readDestinationsFile reads a file in destination-v1's destinations.json
+// format.
+func readDestinationsFile(file string) ([]Destination, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var destinations []Destination
+	if err := json.Unmarshal(b, &destinations); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return destinations, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/bee-travels/bee-travels-go/services/shared/validation"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadDestinationsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantIDs []string
+		wantErr string
+	}{
+		{
+			name:    "destination-v1 format",
+			content: `[{"id": "1", "city": "Paris", "country": "France", "images": ["https://example.com/paris.jpg"]}, {"id": "2", "city": "Rome", "country": "Italy"}]`,
+			wantIDs: []string{"1", "2"},
+		},
+		{name: "empty", content: `[]`},
+		{name: "not a list", content: `{"id": "1"}`, wantErr: "destinations.json: "},
+		{name: "malformed", content: `[{"id": 1}]`, wantErr: "destinations.json: "},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "destinations.json")
+			if err := os.WriteFile(file, []byte(test.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			destinations, err := readDestinationsFile(file)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(destinations) != len(test.wantIDs) {
+				t.Fatalf("read %d destinations, want %d", len(destinations), len(test.wantIDs))
+			}
+			for i, id := range test.wantIDs {
+				if destinations[i].ID != id {
+					t.Errorf("destination %d has id %q, want %q", i, destinations[i].ID, id)
+				}
+			}
+		})
+	}
+}
+
+func TestCountUpserts(t *testing.T) {
+	tests := []struct {
+		name              string
+		written           []upserted
+		inserted, updated int
+	}{
+		{name: "nothing written"},
+		{name: "inserts", written: []upserted{{ID: "1", Inserted: true}, {ID: "2", Inserted: true}}, inserted: 2},
+		{name: "mixed", written: []upserted{{ID: "1", Inserted: true}, {ID: "2", Version: 3}}, inserted: 1, updated: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inserted, updated := countUpserts(test.written)
+			if inserted != test.inserted || updated != test.updated {
+				t.Errorf("countUpserts() = %d, %d, want %d, %d", inserted, updated, test.inserted, test.updated)
+			}
+		})
+	}
+}
+
+func TestCheckSeed(t *testing.T) {
+	paris := Destination{ID: "1", City: "Paris", Country: "France", Latitude: 48.85, Longitude: 2.35, Population: 2161000, Description: "Capital of France"}
+	rome := Destination{ID: "2", City: "Rome", Country: "Italy", Latitude: 41.9, Longitude: 12.5, Population: 2873000, Description: "Capital of Italy"}
+
+	tests := []struct {
+		name         string
+		destinations []Destination
+		prune, force bool
+		wantErr      bool
+		wantRules    []string
+	}{
+		{name: "valid", destinations: []Destination{paris, rome}},
+		{name: "valid with prune", destinations: []Destination{paris}, prune: true},
+		{name: "empty", destinations: []Destination{}},
+		{name: "empty with prune", destinations: []Destination{}, prune: true, wantErr: true},
+		{name: "empty with forced prune", destinations: []Destination{}, prune: true, force: true},
+		{
+			name:         "missing city",
+			destinations: []Destination{{ID: "1", Country: "France", Population: 1, Description: "?"}},
+			wantRules:    []string{validation.MissingField},
+		},
+		{
+			name:         "bad coordinates and population",
+			destinations: []Destination{{ID: "1", City: "Paris", Country: "France", Latitude: 91, Description: "?"}},
+			wantRules:    []string{validation.CoordinateRange, validation.NonPositivePopulation},
+		},
+		{
+			name:         "repeated id",
+			destinations: []Destination{paris, {ID: "1", City: "Rome", Country: "Italy", Population: 1, Description: "?"}},
+			wantRules:    []string{validation.DuplicateID},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := checkSeed("destinations.json", test.destinations, test.prune, test.force)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("checkSeed() error = %v, want error: %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var rules []string
+			for _, issue := range report.Issues {
+				rules = append(rules, issue.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(test.wantRules, ",") {
+				t.Errorf("issues %q, want %q", rules, test.wantRules)
+			}
+			if report.Valid != (len(test.wantRules) == 0) {
+				t.Errorf("valid = %v with issues %q", report.Valid, rules)
+			}
+		})
+	}
+}
+
+func TestSeedRows(t *testing.T) {
+	rows, byID := seedRows([]Destination{
+		{ID: "1", City: "Paris", Country: "France"},
+		{ID: "2", City: "Rome", Country: "Italy", Images: []string{"https://example.com/rome.jpg"}},
+		{ID: "1", City: "Lyon", Country: "France"},
+	})
+
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, want one per id", len(rows))
+	}
+	if rows[0][1] != "Lyon" || byID["1"].City != "Lyon" {
+		t.Errorf("id 1 seeds %v and publishes %s, want the last definition", rows[0][1], byID["1"].City)
+	}
+	for i, row := range rows {
+		images, ok := row[len(row)-1].([]string)
+		if !ok || images == nil {
+			t.Errorf("row %d has images %#v, want a non-nil list", i, row[len(row)-1])
+		}
+	}
+	if byID["1"].Images == nil {
+		t.Error("the published destination has nil images")
+	}
+}
@@ -1,15 +1,66 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
 	"github.com/elgris/sqrl"
 	"github.com/jackc/pgx/v4"
+	"strings"
+	"time"
 )
 
-func queryLocations(pool database.Pool, ctx server.RequestContext, country string) ([]Location, error) {
-	selector := buildBaseQuery(true)
+// destinationColumns are the columns a client can write; version and
+// deleted_at are maintained by the database and the delete endpoints.
+var destinationColumns = []string{"id", "city", "country", "latitude", "longitude", "population", "description", "images"}
+
+var errVersionConflict = errors.New("destination was modified concurrently")
+
+// Destinations change rarely, so current reads go through the cache of the
+// pool when it has one. Writes invalidate them, and so do the changes of
+// other instances, through the change feed.
+const (
+	locationsCacheTTL    = time.Minute
+	destinationsCacheTTL = 30 * time.Second
+)
+
+// upsertSuffix turns an INSERT INTO destination into an upsert by id. Rows
+// whose content is unchanged are left alone and not returned; the others
+// return their id, new version and whether they were inserted rather than
+// updated. Upserting a soft-deleted destination restores it.
+var upsertSuffix = func() string {
+	columns := destinationColumns[1:]
+	updates := make([]string, len(columns), len(columns)+1)
+	current := make([]string, len(columns), len(columns)+1)
+	excluded := make([]string, len(columns), len(columns)+1)
+	for i, column := range columns {
+		updates[i] = fmt.Sprintf("%s = EXCLUDED.%s", column, column)
+		current[i] = "destination." + column
+		excluded[i] = "EXCLUDED." + column
+	}
+	updates = append(updates, "deleted_at = NULL")
+	current = append(current, "destination.deleted_at")
+	excluded = append(excluded, "NULL::timestamptz")
+	return fmt.Sprintf(
+		"ON CONFLICT (id) DO UPDATE SET %s WHERE (%s) IS DISTINCT FROM (%s) RETURNING id, version, (xmax = 0)",
+		strings.Join(updates, ", "),
+		strings.Join(current, ", "),
+		strings.Join(excluded, ", "),
+	)
+}()
+
+// readScope selects the destinations a read sees: the current ones unless
+// AsOf is set, and soft-deleted ones only with IncludeDeleted.
+type readScope struct {
+	AsOf           *time.Time
+	IncludeDeleted bool
+}
+
+func queryLocations(pool database.Pool, ctx server.RequestContext, country string, scope readScope) ([]Location, error) {
+	selector := buildScopedQuery(true, scope)
 	if country != "" {
 		selector.Where("country = ?", country)
 	}
@@ -17,7 +68,7 @@ func queryLocations(pool database.Pool, ctx server.RequestContext, country strin
 	sql, args, _ := selector.ToSql()
 	fmt.Printf("sql: %s, args: %+v", sql, args)
 	locations := make([]Location, 0)
-	err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
+	err := database.QueryFunc(pool, ctx, cachedRead(selector, scope, locationsCacheTTL), func(row pgx.Row) error {
 		var location Location
 		err := row.Scan(&location.Country, &location.City)
 		if err != nil {
@@ -37,24 +88,14 @@ func queryLocations(pool database.Pool, ctx server.RequestContext, country strin
 	return locations, nil
 }
 
-func queryDestinations(pool database.Pool, ctx server.RequestContext, country, city string) ([]Destination, error) {
-	selector := buildBaseQuery(false).
+func queryDestinations(pool database.Pool, ctx server.RequestContext, country, city string, scope readScope) ([]Destination, error) {
+	selector := buildScopedQuery(false, scope).
 		Where("country = ?", country).
 		Where("city = ?", city)
 
 	destinations := make([]Destination, 0)
-	err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
-		var destination Destination
-		err := row.Scan(
-			&destination.ID,
-			&destination.City,
-			&destination.Country,
-			&destination.Latitude,
-			&destination.Longitude,
-			&destination.Population,
-			&destination.Description,
-			&destination.Images,
-		)
+	err := database.QueryFunc(pool, ctx, cachedRead(selector, scope, destinationsCacheTTL), func(row pgx.Row) error {
+		destination, err := scanDestination(row)
 		if err != nil {
 			return err
 		}
@@ -72,12 +113,268 @@ func queryDestinations(pool database.Pool, ctx server.RequestContext, country, c
 	return destinations, nil
 }
 
+// queryAllDestinations reads the whole table outside of a request, for the
+// command line tools.
+func queryAllDestinations(pool database.Pool, ctx context.Context) ([]Destination, error) {
+	sql, args, err := buildBaseQuery(false).OrderBy("id").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	destinations := make([]Destination, 0)
+	for rows.Next() {
+		destination, err := scanDestination(rows)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations, rows.Err()
+}
+
+// queryDestinationsByID returns the stored destinations among ids, by id,
+// including soft-deleted ones.
+func queryDestinationsByID(pool database.Pool, ctx server.RequestContext, ids []string) (map[string]Destination, error) {
+	selector := buildScopedQuery(false, readScope{IncludeDeleted: true}).Where("id = ANY(?)", ids)
+
+	destinations := make(map[string]Destination)
+	err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
+		destination, err := scanDestination(row)
+		if err != nil {
+			return err
+		}
+
+		destinations[destination.ID] = destination
+		return nil
+	})
+
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+	return destinations, nil
+}
+
+// upsertDestinations writes destinations in a single statement and returns
+// the rows it inserted or updated.
+func upsertDestinations(tx pgxpool.Tx, ctx context.Context, destinations []Destination) ([]upserted, error) {
+	insert := database.QueryBuilder().
+		Insert("destination").
+		Columns(destinationColumns...).
+		Suffix(upsertSuffix)
+	for _, d := range destinations {
+		insert.Values(d.ID, d.City, d.Country, d.Latitude, d.Longitude, d.Population, d.Description, d.Images)
+	}
+
+	sql, args, err := insert.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return readUpserts(tx.Query(ctx, sql, args...))
+}
+
+// updateDestination replaces the content of d.ID if it is still at version,
+// and returns its new version. errVersionConflict means it has moved on, or
+// no longer exists.
+func updateDestination(tx pgxpool.Tx, ctx context.Context, d Destination, version int) (int, error) {
+	sql, args, err := database.QueryBuilder().
+		Update("destination").
+		Set("city", d.City).
+		Set("country", d.Country).
+		Set("latitude", d.Latitude).
+		Set("longitude", d.Longitude).
+		Set("population", d.Population).
+		Set("description", d.Description).
+		Set("images", d.Images).
+		Where("id = ?", d.ID).
+		Where("version = ?", version).
+		Where("deleted_at IS NULL").
+		Suffix("RETURNING version").
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var newVersion int
+	err = tx.QueryRow(ctx, sql, args...).Scan(&newVersion)
+	if err == pgx.ErrNoRows {
+		return 0, errVersionConflict
+	}
+	return newVersion, err
+}
+
+// setDestinationDeleted soft-deletes or restores id if it is still at
+// version, and returns its deletion time and new version. errVersionConflict
+// means it has moved on, or is already in the requested state.
+func setDestinationDeleted(tx pgxpool.Tx, ctx context.Context, id string, version int, deleted bool) (*time.Time, int, error) {
+	update := database.QueryBuilder().
+		Update("destination").
+		Where("id = ?", id).
+		Where("version = ?", version).
+		Suffix("RETURNING deleted_at, version")
+	if deleted {
+		update.Set("deleted_at", sqrl.Expr("now()")).Where("deleted_at IS NULL")
+	} else {
+		update.Set("deleted_at", nil).Where("deleted_at IS NOT NULL")
+	}
+
+	sql, args, err := update.ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var deletedAt *time.Time
+	var newVersion int
+	err = tx.QueryRow(ctx, sql, args...).Scan(&deletedAt, &newVersion)
+	if err == pgx.ErrNoRows {
+		return nil, 0, errVersionConflict
+	}
+	return deletedAt, newVersion, err
+}
+
+// purgeDestinations permanently deletes the destinations soft-deleted before
+// cutoff and returns their ids. With dryRun they are only listed.
+func purgeDestinations(tx pgxpool.Tx, ctx context.Context, cutoff time.Time, dryRun bool) ([]string, error) {
+	var sql string
+	var args []interface{}
+	var err error
+	if dryRun {
+		sql, args, err = database.QueryBuilder().
+			Select("id").
+			From("destination").
+			Where("deleted_at < ?", cutoff).
+			OrderBy("id").
+			ToSql()
+	} else {
+		sql, args, err = database.QueryBuilder().
+			Delete().
+			From("destination").
+			Where("deleted_at < ?", cutoff).
+			Suffix("RETURNING id").
+			ToSql()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// upserted is a row written by an upsertSuffix statement.
+type upserted struct {
+	ID       string
+	Version  int
+	Inserted bool
+}
+
+// readUpserts reads the rows returned by an upsertSuffix statement.
+func readUpserts(rows pgx.Rows, err error) ([]upserted, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	written := make([]upserted, 0)
+	for rows.Next() {
+		var u upserted
+		if err := rows.Scan(&u.ID, &u.Version, &u.Inserted); err != nil {
+			return nil, err
+		}
+		written = append(written, u)
+	}
+	return written, rows.Err()
+}
+
+func countUpserts(written []upserted) (inserted int, updated int) {
+	for _, u := range written {
+		if u.Inserted {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	return inserted, updated
+}
+
+func scanDestination(row pgx.Row) (Destination, error) {
+	var destination Destination
+	err := row.Scan(
+		&destination.ID,
+		&destination.City,
+		&destination.Country,
+		&destination.Latitude,
+		&destination.Longitude,
+		&destination.Population,
+		&destination.Description,
+		&destination.Images,
+		&destination.Version,
+		&destination.DeletedAt,
+	)
+	return destination, err
+}
+
+// buildBaseQuery selects the current destinations that aren't deleted.
 func buildBaseQuery(wildcardSelect bool) *sqrl.SelectBuilder {
+	return buildScopedQuery(wildcardSelect, readScope{})
+}
+
+// buildScopedQuery selects the destinations in scope. Past states are
+// rebuilt from the latest history entry of each destination at that time.
+func buildScopedQuery(wildcardSelect bool, scope readScope) *sqrl.SelectBuilder {
+	selector := buildSelect(wildcardSelect)
+	if scope.AsOf == nil {
+		selector.From("destination")
+	} else {
+		// Subqueries keep ? placeholders; the outer query numbers them all.
+		latest := sqrl.Select("DISTINCT ON (destination_id) after").
+			From("destination_history").
+			Where("changed_at <= ?", *scope.AsOf).
+			OrderBy("destination_id", "changed_at DESC", "id DESC")
+		snapshot := sqrl.Select("(jsonb_populate_record(NULL::destination, after)).*").
+			FromSelect(latest, "latest").
+			Where("after IS NOT NULL")
+		selector.FromSelect(snapshot, "destination")
+	}
+	if !scope.IncludeDeleted {
+		selector.Where("deleted_at IS NULL")
+	}
+	return selector
+}
+
+// cachedRead marks selector as cached for ttl, unless it reads the past,
+// which would fill the cache with one-off results.
+func cachedRead(selector sqrl.Sqlizer, scope readScope, ttl time.Duration) sqrl.Sqlizer {
+	if scope.AsOf != nil {
+		return selector
+	}
+	return database.Cached(selector, ttl, "destination")
+}
+
+func buildSelect(wildcardSelect bool) *sqrl.SelectBuilder {
 	selector := database.QueryBuilder().Select()
 	if !wildcardSelect {
-		selector.Columns("id", "city", "country", "latitude", "longitude", "population", "description", "images")
+		selector.Columns(destinationColumns...).Columns("version", "deleted_at")
 	} else {
 		selector.Columns("country", "city")
 	}
-	return selector.From("destination")
+	return selector
 }
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Destination struct {
 	ID          string   `json:"id"`
 	City        string   `json:"city"`
@@ -9,6 +14,11 @@ type Destination struct {
 	Population  int      `json:"population"`
 	Description string   `json:"description"`
 	Images      []string `json:"images"`
+	// Version is only exposed through the ETag header.
+	Version int `json:"-"`
+	// DeletedAt is only set on soft-deleted destinations, which are only
+	// listed to admins.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 type Location struct {
@@ -19,3 +29,61 @@ type Location struct {
 type Error struct {
 	Error string `json:"error"`
 }
+
+// HistoryEntry is one recorded change to a destination. Before is null for
+// an insert and After for a delete. TransactionID, the writing transaction's,
+// orders the entry on the change feed.
+type HistoryEntry struct {
+	ID            int64           `json:"id"`
+	TransactionID int64           `json:"-"`
+	DestinationID string          `json:"destinationId"`
+	Operation     string          `json:"operation"`
+	Actor         string          `json:"actor"`
+	ClaimedActor  *string         `json:"claimedActor,omitempty"`
+	Reason        *string         `json:"reason,omitempty"`
+	ChangedAt     time.Time       `json:"changedAt"`
+	Before        json.RawMessage `json:"before"`
+	After         json.RawMessage `json:"after"`
+}
+
+// WebhookSubscription is a partner endpoint that receives destination
+// changes. Its secret is only returned when the subscription is created.
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Countries []string  `json:"countries"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WebhookDelivery is one change queued for, or delivered to, a subscription.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int64      `json:"subscriptionId"`
+	EventID        int64      `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt,omitempty"`
+	LastStatus     *int       `json:"lastStatus,omitempty"`
+	LastError      *string    `json:"lastError,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// WebhookDeadLetter is a delivery that exhausted its attempts.
+type WebhookDeadLetter struct {
+	ID             int64     `json:"id"`
+	SubscriptionID int64     `json:"subscriptionId"`
+	EventID        int64     `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	Attempts       int       `json:"attempts"`
+	LastStatus     *int      `json:"lastStatus,omitempty"`
+	LastError      *string   `json:"lastError,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	FailedAt       time.Time `json:"failedAt"`
+}
@@ -0,0 +1,26 @@
+package main
+
+import "github.com/bee-travels/bee-travels-go/services/shared/validation"
+
+// checkDestinations applies the data-quality rules shared with
+// destination-v1's validate command. Issues are reported by the index of
+// the destination in destinations.
+func checkDestinations(report *validation.Report, destinations []Destination) {
+	items := make([]validation.Item, len(destinations))
+	for i, d := range destinations {
+		items[i] = validation.Item{
+			Index: i,
+			Destination: validation.Destination{
+				ID:          d.ID,
+				City:        d.City,
+				Country:     d.Country,
+				Latitude:    d.Latitude,
+				Longitude:   d.Longitude,
+				Population:  d.Population,
+				Description: d.Description,
+				Images:      d.Images,
+			},
+		}
+	}
+	validation.Check(report, items)
+}
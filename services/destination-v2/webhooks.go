@@ -0,0 +1,427 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/server"
+	"github.com/elgris/sqrl"
+	"github.com/jackc/pgx/v4"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+
+	maxDeliveriesListed = 100
+)
+
+var (
+	webhookColumns     = []string{"id", "url", "events", "countries", "active", "created_at", "updated_at"}
+	deliveryColumns    = []string{"id", "subscription_id", "history_id", "event_type", "status", "attempts", "next_attempt_at", "last_attempt_at", "last_status", "last_error", "created_at", "delivered_at"}
+	deadLetterColumns  = []string{"id", "subscription_id", "history_id", "event_type", "attempts", "last_status", "last_error", "created_at", "failed_at"}
+	webhookEventTypes  = []string{changeCreate, changeUpdate, changeDelete}
+	errWebhookNotFound = fmt.Errorf("webhook not found")
+)
+
+// webhookInput is the body of webhook creations and updates. Fields left out
+// of an update keep their value.
+type webhookInput struct {
+	URL       *string   `json:"url"`
+	Secret    *string   `json:"secret"`
+	Events    *[]string `json:"events"`
+	Countries *[]string `json:"countries"`
+	Active    *bool     `json:"active"`
+}
+
+// apply validates the input and sets it on subscription.
+func (in webhookInput) apply(subscription *WebhookSubscription) error {
+	if in.URL != nil {
+		u, err := url.Parse(*in.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an absolute http(s) URL")
+		}
+		subscription.URL = *in.URL
+	}
+	if in.Secret != nil {
+		if len(*in.Secret) < 16 {
+			return fmt.Errorf("secret must be at least 16 characters")
+		}
+		subscription.Secret = *in.Secret
+	}
+	if in.Events != nil {
+		for _, event := range *in.Events {
+			if !contains(webhookEventTypes, event) {
+				return fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(webhookEventTypes, ", "))
+			}
+		}
+		subscription.Events = *in.Events
+	}
+	if in.Countries != nil {
+		countries := make([]string, len(*in.Countries))
+		for i, country := range *in.Countries {
+			countries[i] = strings.ToLower(strings.TrimSpace(country))
+		}
+		subscription.Countries = countries
+	}
+	if in.Active != nil {
+		subscription.Active = *in.Active
+	}
+	return nil
+}
+
+func listWebhooks(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		selector := database.QueryBuilder().Select(webhookColumns...).From("webhook_subscription").OrderBy("id")
+
+		subscriptions := make([]WebhookSubscription, 0)
+		err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
+			subscription, err := scanWebhook(row)
+			if err != nil {
+				return err
+			}
+
+			subscriptions = append(subscriptions, subscription)
+			return nil
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		server.Response(ctx, http.StatusOK, subscriptions)
+	}
+}
+
+// createWebhook registers a subscription. Without a secret in the body, one
+// is generated; either way it is returned only in this response.
+func createWebhook(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		in, ok := readWebhookInput(ctx)
+		if !ok {
+			return
+		}
+		if in.URL == nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
+				Error: "url is required",
+			})
+			return
+		}
+
+		subscription := WebhookSubscription{Events: make([]string, 0), Countries: make([]string, 0), Active: true}
+		if err := in.apply(&subscription); err != nil {
+			server.Response(ctx, http.StatusUnprocessableEntity, Error{
+				Error: err.Error(),
+			})
+			return
+		}
+		if in.URL != nil {
+			if err := checkWebhookURL(ctx.Request().Context(), subscription.URL); err != nil {
+				server.Response(ctx, http.StatusUnprocessableEntity, Error{
+					Error: err.Error(),
+				})
+				return
+			}
+		}
+		if subscription.Secret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				requestFailed(ctx, http.StatusInternalServerError, err)
+				return
+			}
+			subscription.Secret = hex.EncodeToString(secret)
+		}
+
+		insert := database.QueryBuilder().
+			Insert("webhook_subscription").
+			Columns("url", "secret", "events", "countries", "active").
+			Values(subscription.URL, subscription.Secret, subscription.Events, subscription.Countries, subscription.Active).
+			Suffix("RETURNING id, created_at, updated_at")
+		err := database.QueryRow(pool, ctx, insert).Scan(&subscription.ID, &subscription.CreatedAt, &subscription.UpdatedAt)
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		server.Response(ctx, http.StatusCreated, subscription)
+	}
+}
+
+func getWebhook(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		subscription, ok := findWebhook(pool, ctx)
+		if !ok {
+			return
+		}
+		server.Response(ctx, http.StatusOK, subscription)
+	}
+}
+
+// updateWebhook changes the fields present in the body. A new secret is
+// taken as given and not echoed back.
+func updateWebhook(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		subscription, ok := findWebhook(pool, ctx)
+		if !ok {
+			return
+		}
+		in, ok := readWebhookInput(ctx)
+		if !ok {
+			return
+		}
+		if err := in.apply(&subscription); err != nil {
+			server.Response(ctx, http.StatusUnprocessableEntity, Error{
+				Error: err.Error(),
+			})
+			return
+		}
+		if in.URL != nil {
+			if err := checkWebhookURL(ctx.Request().Context(), subscription.URL); err != nil {
+				server.Response(ctx, http.StatusUnprocessableEntity, Error{
+					Error: err.Error(),
+				})
+				return
+			}
+		}
+
+		update := database.QueryBuilder().
+			Update("webhook_subscription").
+			Set("url", subscription.URL).
+			Set("events", subscription.Events).
+			Set("countries", subscription.Countries).
+			Set("active", subscription.Active).
+			Set("updated_at", sqrl.Expr("now()")).
+			Where("id = ?", subscription.ID).
+			Suffix("RETURNING updated_at")
+		if subscription.Secret != "" {
+			update.Set("secret", subscription.Secret)
+		}
+		err := database.QueryRow(pool, ctx, update).Scan(&subscription.UpdatedAt)
+		if err == pgx.ErrNoRows {
+			server.Response(ctx, http.StatusNotFound, Error{
+				Error: errWebhookNotFound.Error(),
+			})
+			return
+		}
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		subscription.Secret = ""
+		server.Response(ctx, http.StatusOK, subscription)
+	}
+}
+
+// deleteWebhook removes a subscription together with its queued deliveries
+// and dead letters.
+func deleteWebhook(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		id, ok := webhookID(ctx)
+		if !ok {
+			return
+		}
+		tag, err := database.Exec(pool, ctx, database.QueryBuilder().Delete().From("webhook_subscription").Where("id = ?", id))
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			server.Response(ctx, http.StatusNotFound, Error{
+				Error: errWebhookNotFound.Error(),
+			})
+			return
+		}
+		ctx.StatusCode(http.StatusNoContent)
+	}
+}
+
+// listWebhookDeliveries returns a subscription's most recent deliveries,
+// optionally only those with the given status.
+func listWebhookDeliveries(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		id, ok := webhookID(ctx)
+		if !ok {
+			return
+		}
+		selector := database.QueryBuilder().
+			Select(deliveryColumns...).
+			From("webhook_delivery").
+			Where("subscription_id = ?", id).
+			OrderBy("id DESC").
+			Limit(maxDeliveriesListed)
+		if status := ctx.URLParam("status"); status != "" {
+			if status != deliveryPending && status != deliveryDelivered {
+				server.Response(ctx, http.StatusBadRequest, Error{
+					Error: fmt.Sprintf("status must be %s or %s", deliveryPending, deliveryDelivered),
+				})
+				return
+			}
+			selector.Where("status = ?", status)
+		}
+
+		deliveries := make([]WebhookDelivery, 0)
+		err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
+			var d WebhookDelivery
+			err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+				&d.NextAttemptAt, &d.LastAttemptAt, &d.LastStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+			if err != nil {
+				return err
+			}
+			if d.Status != deliveryPending {
+				d.NextAttemptAt = nil
+			}
+
+			deliveries = append(deliveries, d)
+			return nil
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		server.Response(ctx, http.StatusOK, deliveries)
+	}
+}
+
+func listWebhookDeadLetters(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		id, ok := webhookID(ctx)
+		if !ok {
+			return
+		}
+		selector := database.QueryBuilder().
+			Select(deadLetterColumns...).
+			From("webhook_dead_letter").
+			Where("subscription_id = ?", id).
+			OrderBy("id DESC").
+			Limit(maxDeliveriesListed)
+
+		letters := make([]WebhookDeadLetter, 0)
+		err := database.QueryFunc(pool, ctx, selector, func(row pgx.Row) error {
+			var l WebhookDeadLetter
+			err := row.Scan(&l.ID, &l.SubscriptionID, &l.EventID, &l.EventType, &l.Attempts,
+				&l.LastStatus, &l.LastError, &l.CreatedAt, &l.FailedAt)
+			if err != nil {
+				return err
+			}
+
+			letters = append(letters, l)
+			return nil
+		})
+		if err != nil && err != pgx.ErrNoRows {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		server.Response(ctx, http.StatusOK, letters)
+	}
+}
+
+// retryWebhookDeadLetter queues a dead letter for delivery again, with a
+// fresh set of attempts.
+func retryWebhookDeadLetter(pool database.Pool) server.RequestHandler {
+	return func(ctx server.RequestContext) {
+		id, ok := webhookID(ctx)
+		if !ok {
+			return
+		}
+		letter, err := ctx.Params().GetInt64("letter")
+		if err != nil {
+			server.Response(ctx, http.StatusBadRequest, Error{
+				Error: err.Error(),
+			})
+			return
+		}
+
+		var deliveryID int64
+		err = database.WithTx(pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+			return tx.QueryRow(ctx.Request().Context(), `
+				WITH letter AS (
+					DELETE FROM webhook_dead_letter WHERE id = $1 AND subscription_id = $2
+					RETURNING subscription_id, history_id, event_type
+				)
+				INSERT INTO webhook_delivery (subscription_id, history_id, event_type)
+				SELECT subscription_id, history_id, event_type FROM letter
+				ON CONFLICT (subscription_id, history_id) DO UPDATE
+				SET status = 'pending', attempts = 0, next_attempt_at = now(), delivered_at = NULL,
+				    last_attempt_at = NULL, last_status = NULL, last_error = NULL
+				RETURNING id`, letter, id).Scan(&deliveryID)
+		})
+		if err == pgx.ErrNoRows {
+			server.Response(ctx, http.StatusNotFound, Error{
+				Error: "dead letter not found",
+			})
+			return
+		}
+		if err != nil {
+			requestFailed(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		server.Response(ctx, http.StatusAccepted, map[string]int64{"deliveryId": deliveryID})
+	}
+}
+
+// findWebhook loads the subscription named by the id path parameter,
+// answering the request itself when it can't.
+func findWebhook(pool database.Pool, ctx server.RequestContext) (WebhookSubscription, bool) {
+	id, ok := webhookID(ctx)
+	if !ok {
+		return WebhookSubscription{}, false
+	}
+	selector := database.QueryBuilder().Select(webhookColumns...).From("webhook_subscription").Where("id = ?", id)
+	subscription, err := scanWebhook(database.QueryRow(pool, ctx, selector))
+	if err == pgx.ErrNoRows {
+		server.Response(ctx, http.StatusNotFound, Error{
+			Error: errWebhookNotFound.Error(),
+		})
+		return subscription, false
+	}
+	if err != nil {
+		requestFailed(ctx, http.StatusInternalServerError, err)
+		return subscription, false
+	}
+	return subscription, true
+}
+
+func webhookID(ctx server.RequestContext) (int64, bool) {
+	id, err := ctx.Params().GetInt64("id")
+	if err != nil {
+		server.Response(ctx, http.StatusBadRequest, Error{
+			Error: err.Error(),
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+func readWebhookInput(ctx server.RequestContext) (webhookInput, bool) {
+	var in webhookInput
+	body, err := ioutil.ReadAll(ctx.Request().Body)
+	if err == nil {
+		err = json.Unmarshal(body, &in)
+	}
+	if err != nil {
+		server.Response(ctx, http.StatusBadRequest, Error{
+			Error: err.Error(),
+		})
+		return in, false
+	}
+	return in, true
+}
+
+func scanWebhook(row pgx.Row) (WebhookSubscription, error) {
+	var subscription WebhookSubscription
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.URL,
+		&subscription.Events,
+		&subscription.Countries,
+		&subscription.Active,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	)
+	return subscription, err
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgconn"
+	"sync"
+	"time"
+)
+
+const breakerBuckets = 10
+
+type BreakerState int
+
+const (
+	// BreakerClosed lets every statement through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen refuses every statement until OpenDuration has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a few probes through: the breaker closes once
+	// they all succeed, and opens again as soon as one fails.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type BreakerOptions struct {
+	// FailureRate is the share of failed statements in the window that
+	// opens the breaker.
+	FailureRate float64
+	// MinRequests is how many statements the window needs before the
+	// failure rate is considered.
+	MinRequests int
+	Window      time.Duration
+	// OpenDuration is how long the breaker stays open before it probes.
+	OpenDuration   time.Duration
+	HalfOpenProbes int
+}
+
+// Breaker stops sending statements to a database that fails or times out,
+// so that callers get an error at once instead of piling up behind it.
+// Errors of the statements themselves, such as constraint violations, and
+// cancellations by the caller don't count as failures.
+type Breaker struct {
+	options BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	buckets  [breakerBuckets]breakerBucket
+	probes   int
+	probed   int
+}
+
+type breakerBucket struct {
+	index    int64
+	total    int
+	failures int
+}
+
+func NewBreaker(options BreakerOptions) *Breaker {
+	if options.FailureRate <= 0 {
+		options.FailureRate = 0.5
+	}
+	if options.MinRequests <= 0 {
+		options.MinRequests = 20
+	}
+	if options.Window <= 0 {
+		options.Window = 10 * time.Second
+	}
+	if options.OpenDuration <= 0 {
+		options.OpenDuration = 5 * time.Second
+	}
+	if options.HalfOpenProbes <= 0 {
+		options.HalfOpenProbes = 3
+	}
+	return &Breaker{options: options}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a statement can run, and whether it is one of the
+// probes of a half-open breaker. Its outcome must then be passed to record.
+func (b *Breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == BreakerOpen {
+		if wait := b.options.OpenDuration - now.Sub(b.openedAt); wait > 0 {
+			return false, &UnavailableError{Reason: "circuit breaker open", RetryAfter: wait}
+		}
+		b.setState(BreakerHalfOpen)
+		b.probes, b.probed = 0, 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.options.HalfOpenProbes {
+			return false, &UnavailableError{Reason: "circuit breaker half-open", RetryAfter: time.Second}
+		}
+		b.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (b *Breaker) record(probe bool, err error) {
+	failed, counts := breakerOutcome(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if probe {
+		if b.state != BreakerHalfOpen {
+			return
+		}
+		b.probes--
+		switch {
+		case failed:
+			b.open(now)
+		case counts:
+			if b.probed++; b.probed >= b.options.HalfOpenProbes {
+				b.buckets = [breakerBuckets]breakerBucket{}
+				b.setState(BreakerClosed)
+			}
+		}
+		return
+	}
+
+	if b.state != BreakerClosed || !counts {
+		return
+	}
+	index := now.UnixNano() / int64(b.options.Window/breakerBuckets)
+	bucket := &b.buckets[index%breakerBuckets]
+	if bucket.index != index {
+		*bucket = breakerBucket{index: index}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+
+	var total, failures int
+	for _, bucket := range b.buckets {
+		if index-bucket.index < breakerBuckets {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	if total >= b.options.MinRequests && float64(failures) >= b.options.FailureRate*float64(total) {
+		b.open(now)
+	}
+}
+
+func (b *Breaker) open(now time.Time) {
+	b.openedAt = now
+	b.setState(BreakerOpen)
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state != state {
+		fmt.Printf("Database circuit breaker is now %s\n", state)
+	}
+	b.state = state
+}
+
+// breakerOutcome tells whether err is a failure of the database, and
+// whether it counts at all: a caller that gave up tells nothing about it.
+func breakerOutcome(err error) (failed bool, counts bool) {
+	switch pgxpool.Classify(err) {
+	case pgxpool.ConnectionError:
+		return true, true
+	case pgxpool.Canceled:
+		timedOut := errors.Is(err, context.DeadlineExceeded)
+		return timedOut, timedOut
+	case pgxpool.QueryError:
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "57014" { // query_canceled, by statement_timeout
+			return true, true
+		}
+	}
+	return false, true
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"testing"
+	"time"
+)
+
+const testOpenDuration = 200 * time.Millisecond
+
+var (
+	errConnection       = &pgconn.PgError{Code: "08006"}
+	errUniqueViolation  = &pgconn.PgError{Code: "23505"}
+	errStatementTimeout = &pgconn.PgError{Code: "57014"}
+)
+
+// breakerStep runs a statement that returns err, expects the breaker to
+// refuse it, or lets the open duration pass.
+type breakerStep struct {
+	err     error
+	wait    bool
+	refused bool
+}
+
+func succeeds() breakerStep       { return breakerStep{} }
+func fails(err error) breakerStep { return breakerStep{err: err} }
+func refused() breakerStep        { return breakerStep{refused: true} }
+func waitOpen() breakerStep       { return breakerStep{wait: true} }
+
+func times(n int, step breakerStep) []breakerStep {
+	steps := make([]breakerStep, n)
+	for i := range steps {
+		steps[i] = step
+	}
+	return steps
+}
+
+// steps flattens single steps and repeated ones into a sequence.
+func steps(groups ...interface{}) []breakerStep {
+	var all []breakerStep
+	for _, group := range groups {
+		switch group := group.(type) {
+		case breakerStep:
+			all = append(all, group)
+		case []breakerStep:
+			all = append(all, group...)
+		}
+	}
+	return all
+}
+
+func newTestBreaker() *Breaker {
+	return NewBreaker(BreakerOptions{
+		FailureRate:    0.5,
+		MinRequests:    4,
+		Window:         time.Minute,
+		OpenDuration:   testOpenDuration,
+		HalfOpenProbes: 2,
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []breakerStep
+		want  BreakerState
+	}{
+		{
+			name:  "too few statements",
+			steps: times(3, fails(errConnection)),
+			want:  BreakerClosed,
+		},
+		{
+			name:  "failure rate below the threshold",
+			steps: steps(times(3, succeeds()), fails(errConnection), succeeds(), fails(errConnection)),
+			want:  BreakerClosed,
+		},
+		{
+			name:  "failure rate reached",
+			steps: steps(succeeds(), succeeds(), fails(errConnection), fails(errConnection), refused()),
+			want:  BreakerOpen,
+		},
+		{
+			name:  "errors of the statements don't count",
+			steps: times(6, fails(errUniqueViolation)),
+			want:  BreakerClosed,
+		},
+		{
+			name:  "cancellations are left out",
+			steps: steps(times(4, fails(context.Canceled)), times(3, fails(errConnection))),
+			want:  BreakerClosed,
+		},
+		{
+			name:  "timeouts count",
+			steps: times(4, fails(context.DeadlineExceeded)),
+			want:  BreakerOpen,
+		},
+		{
+			name:  "statement timeouts count",
+			steps: times(4, fails(errStatementTimeout)),
+			want:  BreakerOpen,
+		},
+		{
+			name:  "probing after the open duration",
+			steps: steps(times(4, fails(errConnection)), waitOpen(), succeeds()),
+			want:  BreakerHalfOpen,
+		},
+		{
+			name:  "successful probes close it",
+			steps: steps(times(4, fails(errConnection)), waitOpen(), succeeds(), succeeds()),
+			want:  BreakerClosed,
+		},
+		{
+			name:  "a failed probe opens it again",
+			steps: steps(times(4, fails(errConnection)), waitOpen(), succeeds(), fails(errConnection), refused()),
+			want:  BreakerOpen,
+		},
+		{
+			name:  "closing forgets the earlier failures",
+			steps: steps(times(4, fails(errConnection)), waitOpen(), succeeds(), succeeds(), times(3, fails(errConnection))),
+			want:  BreakerClosed,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			b := newTestBreaker()
+			for i, step := range test.steps {
+				if step.wait {
+					time.Sleep(testOpenDuration + 50*time.Millisecond)
+					continue
+				}
+				probe, err := b.allow()
+				if step.refused {
+					var unavailable *UnavailableError
+					if !errors.As(err, &unavailable) || unavailable.RetryAfter <= 0 {
+						t.Fatalf("step %d: allow() error = %v, want a refusal with Retry-After", i, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d: allow() error = %v, want the statement to run", i, err)
+				}
+				b.record(probe, step.err)
+			}
+			if state := b.State(); state != test.want {
+				t.Errorf("state %s, want %s", state, test.want)
+			}
+		})
+	}
+}
+
+func TestBreakerLimitsProbes(t *testing.T) {
+	b := newTestBreaker()
+	for i := 0; i < 4; i++ {
+		probe, _ := b.allow()
+		b.record(probe, errConnection)
+	}
+	time.Sleep(testOpenDuration + 50*time.Millisecond)
+
+	var probes []bool
+	for i := 0; i < 2; i++ {
+		probe, err := b.allow()
+		if err != nil {
+			t.Fatalf("probe %d refused: %v", i, err)
+		}
+		probes = append(probes, probe)
+	}
+	if _, err := b.allow(); err == nil {
+		t.Fatal("a third statement ran while two probes were in flight")
+	}
+
+	// A probe the caller gave up on frees its slot without deciding.
+	b.record(probes[0], context.Canceled)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state %s after a canceled probe, want half-open", b.State())
+	}
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("allow() = %v, %v after a probe was freed, want another probe", probe, err)
+	}
+}
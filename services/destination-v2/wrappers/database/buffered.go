@@ -0,0 +1,174 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"sync"
+)
+
+// bufferedResult is the whole result of a query, which can be read again
+// and by several callers. It keeps the raw values sent by the server, which
+// are decoded again on every Scan, so that callers can't share or modify
+// what they read.
+type bufferedResult struct {
+	fields []pgproto3.FieldDescription
+	rows   [][][]byte
+	tag    pgconn.CommandTag
+}
+
+// readResult reads and closes rows.
+func readResult(rows pgx.Rows, err error) (*bufferedResult, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &bufferedResult{}
+	for rows.Next() {
+		raw := rows.RawValues()
+		row := make([][]byte, len(raw))
+		for i, value := range raw {
+			if value != nil {
+				row[i] = append([]byte{}, value...)
+			}
+		}
+		result.rows = append(result.rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result.fields = append(result.fields, rows.FieldDescriptions()...)
+	result.tag = append(pgconn.CommandTag{}, rows.CommandTag()...)
+	return result, nil
+}
+
+// firstRow is the pgx.Row of rows, for QueryRow.
+type firstRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r firstRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		return pgx.ErrNoRows
+	}
+	return r.rows.Scan(dest...)
+}
+
+// connInfos are shared by bufferedRows. A ConnInfo decodes into values that it
+// keeps between calls, so it can't be used by two of them at once.
+var connInfos = sync.Pool{New: func() interface{} { return pgtype.NewConnInfo() }}
+
+// bufferedRows reads a bufferedResult.
+type bufferedRows struct {
+	result   *bufferedResult
+	connInfo *pgtype.ConnInfo
+	index    int
+}
+
+func (r *bufferedRows) info() *pgtype.ConnInfo {
+	if r.connInfo == nil {
+		r.connInfo = connInfos.Get().(*pgtype.ConnInfo)
+	}
+	return r.connInfo
+}
+
+func (r *bufferedRows) Close() {
+	r.index = len(r.result.rows)
+	if r.connInfo != nil {
+		connInfos.Put(r.connInfo)
+		r.connInfo = nil
+	}
+}
+
+func (r *bufferedRows) Err() error {
+	return nil
+}
+
+func (r *bufferedRows) CommandTag() pgconn.CommandTag {
+	return r.result.tag
+}
+
+func (r *bufferedRows) FieldDescriptions() []pgproto3.FieldDescription {
+	return r.result.fields
+}
+
+func (r *bufferedRows) Next() bool {
+	if r.index < len(r.result.rows) {
+		r.index++
+	}
+	return r.index < len(r.result.rows)
+}
+
+func (r *bufferedRows) Scan(dest ...interface{}) error {
+	row := r.RawValues()
+	if row == nil {
+		return errors.New("no row to scan")
+	}
+	if len(dest) != len(row) {
+		return fmt.Errorf("number of field descriptions must equal number of destinations, got %d and %d", len(row), len(dest))
+	}
+	for i, d := range dest {
+		if d == nil {
+			continue
+		}
+		field := r.result.fields[i]
+		if err := r.info().Scan(field.DataTypeOID, field.Format, row[i], d); err != nil {
+			return fmt.Errorf("can't scan into dest[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *bufferedRows) Values() ([]interface{}, error) {
+	row := r.RawValues()
+	values := make([]interface{}, 0, len(row))
+	for i, buf := range row {
+		field := r.result.fields[i]
+		dt, ok := r.info().DataTypeForOID(field.DataTypeOID)
+		if buf == nil || !ok {
+			if buf == nil {
+				values = append(values, nil)
+			} else {
+				values = append(values, string(buf))
+			}
+			continue
+		}
+
+		value := pgtype.NewValue(dt.Value)
+		var err error
+		if field.Format == pgx.BinaryFormatCode {
+			decoder, ok := value.(pgtype.BinaryDecoder)
+			if !ok {
+				return nil, fmt.Errorf("can't decode field %s", field.Name)
+			}
+			err = decoder.DecodeBinary(r.info(), buf)
+		} else {
+			decoder, ok := value.(pgtype.TextDecoder)
+			if !ok {
+				return nil, fmt.Errorf("can't decode field %s", field.Name)
+			}
+			err = decoder.DecodeText(r.info(), buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value.Get())
+	}
+	return values, nil
+}
+
+func (r *bufferedRows) RawValues() [][]byte {
+	if r.index < 0 || r.index >= len(r.result.rows) {
+		return nil
+	}
+	return r.result.rows[r.index]
+}
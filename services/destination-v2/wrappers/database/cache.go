@@ -0,0 +1,453 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/elgris/sqrl"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	ot "github.com/opentracing/opentracing-go"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CachedQuery is a query whose result may be served from the cache of the
+// pool, for TTL or until one of the tables in Tags is written. Query,
+// QueryRow and QueryFunc read through the cache when the pool has one, and
+// run the query as usual otherwise.
+type CachedQuery struct {
+	sqrl.Sqlizer
+	// TTL is how long the result is served; zero means the default of the
+	// cache.
+	TTL  time.Duration
+	Tags []string
+}
+
+func Cached(query sqrl.Sqlizer, ttl time.Duration, tags ...string) CachedQuery {
+	return CachedQuery{Sqlizer: query, TTL: ttl, Tags: tags}
+}
+
+type CacheOptions struct {
+	// Entries bounds the results kept; the least recently used go first.
+	Entries int
+	TTL     time.Duration
+}
+
+// Cache keeps query results by SQL and arguments.
+type Cache struct {
+	options CacheOptions
+
+	mu          sync.Mutex
+	entries     map[string]*list.Element
+	lru         *list.List
+	generations map[string]uint64
+
+	hits, misses, evictions, invalidations int64
+}
+
+type cacheEntry struct {
+	key     string
+	result  *bufferedResult
+	tags    []string
+	expires time.Time
+}
+
+func NewCache(options CacheOptions) *Cache {
+	if options.Entries <= 0 {
+		options.Entries = 1000
+	}
+	if options.TTL <= 0 {
+		options.TTL = 30 * time.Second
+	}
+	return &Cache{
+		options:     options,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		generations: make(map[string]uint64),
+	}
+}
+
+// Invalidate drops the results tagged with any of tags, and those of the
+// queries that are reading them.
+func (c *Cache) Invalidate(tags ...string) {
+	if len(tags) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		c.generations[tag]++
+	}
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if entry := element.Value.(*cacheEntry); sharesTag(entry.tags, tags) {
+			c.remove(element)
+			c.invalidations++
+		}
+		element = next
+	}
+}
+
+// rows runs query, unless its result is cached, and returns rows that read
+// the result.
+func (c *Cache) rows(pool Pool, ctx context.Context, span ot.Span, query CachedQuery, sql string, args []interface{}) (pgx.Rows, error) {
+	key := cacheKey(sql, args)
+	if result, ok := c.get(key); ok {
+		atomic.AddInt64(&c.hits, 1)
+		tagSpan(span, "cache", "hit")
+		return &bufferedRows{result: result, index: -1}, nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+	tagSpan(span, "cache", "miss")
+
+	generation := c.generation(query.Tags)
+	result, err := readResult(pool.Query(fillContext(ctx), sql, args...))
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := query.TTL
+	if ttl <= 0 {
+		ttl = c.options.TTL
+	}
+	c.put(key, result, query.Tags, ttl, generation)
+	return &bufferedRows{result: result, index: -1}, nil
+}
+
+// cacheFillKey marks the context of a query that fills the cache.
+type cacheFillKey struct{}
+
+// fillContext reads from the primary: after a write invalidates a result,
+// a replica that lags behind would put the old one back for a whole TTL.
+func fillContext(ctx context.Context) context.Context {
+	return context.WithValue(pgxpool.WithPrimary(ctx), cacheFillKey{}, true)
+}
+
+func isCacheFill(ctx context.Context) bool {
+	fill, _ := ctx.Value(cacheFillKey{}).(bool)
+	return fill
+}
+
+func (c *Cache) get(key string) (*bufferedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+// generation identifies the state of tags: it changes whenever one of them
+// is invalidated.
+func (c *Cache) generation(tags []string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var generation uint64
+	for _, tag := range tags {
+		generation += c.generations[tag]
+	}
+	return generation
+}
+
+// put keeps result, unless its tags were invalidated since generation, in
+// which case it may already be stale.
+func (c *Cache) put(key string, result *bufferedResult, tags []string, ttl time.Duration, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var current uint64
+	for _, tag := range tags {
+		current += c.generations[tag]
+	}
+	if current != generation {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	entry := &cacheEntry{key: key, result: result, tags: tags, expires: time.Now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.options.Entries {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+type CacheStats struct {
+	Entries       int   `json:"entries"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:       c.lru.Len(),
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+	}
+}
+
+func cacheKey(sql string, args []interface{}) string {
+	var key strings.Builder
+	key.WriteString(sql)
+	for _, arg := range args {
+		fmt.Fprintf(&key, "\x00%T:%v", arg, arg)
+	}
+	return key.String()
+}
+
+func sharesTag(tags, others []string) bool {
+	for _, tag := range tags {
+		for _, other := range others {
+			if tag == other {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func tagSpan(span ot.Span, key string, value interface{}) {
+	if span != nil {
+		span.SetTag(key, value)
+	}
+}
+
+// CachedPool keeps the results of cached queries, and invalidates the
+// tables that its statements write, once they are committed.
+type CachedPool struct {
+	pool  Pool
+	cache *Cache
+}
+
+func WithCache(pool Pool, cache *Cache) *CachedPool {
+	return &CachedPool{pool: pool, cache: cache}
+}
+
+// Pool returns the pool that p caches.
+func (p *CachedPool) Pool() Pool {
+	return p.pool
+}
+
+func (p *CachedPool) Cache() *Cache {
+	return p.cache
+}
+
+func (p *CachedPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	tag, err := p.pool.Exec(ctx, sql, args...)
+	if err == nil {
+		p.cache.Invalidate(writtenTables(sql)...)
+	}
+	return tag, err
+}
+
+func (p *CachedPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if tables := writtenTables(sql); err == nil && len(tables) > 0 {
+		return &invalidatingRows{Rows: rows, cache: p.cache, tables: tables}, nil
+	}
+	return rows, err
+}
+
+func (p *CachedPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	row := p.pool.QueryRow(ctx, sql, args...)
+	if tables := writtenTables(sql); len(tables) > 0 {
+		return invalidatingRow{row: row, cache: p.cache, tables: tables}
+	}
+	return row
+}
+
+func (p *CachedPool) Begin(ctx context.Context) (pgxpool.Tx, error) {
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (p *CachedPool) BeginTx(ctx context.Context, options pgx.TxOptions) (pgxpool.Tx, error) {
+	tx, err := p.pool.BeginTx(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return &cachingTx{Tx: tx, cache: p.cache}, nil
+}
+
+// cachingTx remembers the tables written in a transaction, which are
+// invalidated once it commits.
+type cachingTx struct {
+	pgxpool.Tx
+	cache   *Cache
+	mu      sync.Mutex
+	written []string
+}
+
+func (t *cachingTx) write(sql string) {
+	if tables := writtenTables(sql); len(tables) > 0 {
+		t.mu.Lock()
+		t.written = append(t.written, tables...)
+		t.mu.Unlock()
+	}
+}
+
+func (t *cachingTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	t.write(sql)
+	return t.Tx.Exec(ctx, sql, args...)
+}
+
+func (t *cachingTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	t.write(sql)
+	return t.Tx.Query(ctx, sql, args...)
+}
+
+func (t *cachingTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	t.write(sql)
+	return t.Tx.QueryRow(ctx, sql, args...)
+}
+
+func (t *cachingTx) Commit(ctx context.Context) error {
+	err := t.Tx.Commit(ctx)
+	if err == nil {
+		t.mu.Lock()
+		written := t.written
+		t.mu.Unlock()
+		t.cache.Invalidate(written...)
+	}
+	return err
+}
+
+type invalidatingRows struct {
+	pgx.Rows
+	cache  *Cache
+	tables []string
+	once   sync.Once
+}
+
+func (r *invalidatingRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.invalidate()
+	return false
+}
+
+func (r *invalidatingRows) Close() {
+	r.Rows.Close()
+	r.invalidate()
+}
+
+func (r *invalidatingRows) invalidate() {
+	r.once.Do(func() {
+		r.cache.Invalidate(r.tables...)
+	})
+}
+
+type invalidatingRow struct {
+	row    pgx.Row
+	cache  *Cache
+	tables []string
+}
+
+func (r invalidatingRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if err == nil || err == pgx.ErrNoRows {
+		r.cache.Invalidate(r.tables...)
+	}
+	return err
+}
+
+// writePattern finds the tables written by INSERT, UPDATE, DELETE and
+// TRUNCATE. An UPDATE must be followed by SET, so that neither FOR UPDATE
+// nor ON CONFLICT DO UPDATE match.
+var writePattern = regexp.MustCompile(`(?i)\b(?:INSERT\s+INTO|DELETE\s+FROM|TRUNCATE(?:\s+TABLE)?)\s+(?:ONLY\s+)?` + tablePattern +
+	`|\bUPDATE\s+(?:ONLY\s+)?` + tablePattern + `(?:\s+(?:AS\s+)?\w+)?\s+SET\b`)
+
+// tablePattern matches a table name, which may be quoted and qualified by
+// its schema.
+const tablePattern = `((?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?)`
+
+// writtenTables finds the tables that sql writes, without their schema.
+func writtenTables(sql string) []string {
+	var tables []string
+	for _, match := range writePattern.FindAllStringSubmatch(sql, -1) {
+		table := match[1] + match[2]
+		if i := strings.LastIndex(table, "."); i >= 0 {
+			table = table[i+1:]
+		}
+		tables = append(tables, strings.ToLower(strings.Trim(table, `"`)))
+	}
+	return tables
+}
+
+// queryRows runs sql, through the cache of pool when query is cached.
+// Contexts that read from the primary, to see their own writes, bypass it.
+func queryRows(pool Pool, ctx context.Context, span ot.Span, query sqrl.Sqlizer, sql string, args []interface{}) (pgx.Rows, error) {
+	if cache, cached, ok := cacheFor(pool, ctx, query); ok {
+		return cache.rows(pool, ctx, span, cached, sql, args)
+	}
+	return pool.Query(ctx, sql, args...)
+}
+
+// queryRow runs sql like queryRows, for a single row.
+func queryRow(pool Pool, ctx context.Context, span ot.Span, query sqrl.Sqlizer, sql string, args []interface{}) pgx.Row {
+	if cache, cached, ok := cacheFor(pool, ctx, query); ok {
+		rows, err := cache.rows(pool, ctx, span, cached, sql, args)
+		return firstRow{rows: rows, err: err}
+	}
+	return pool.QueryRow(ctx, sql, args...)
+}
+
+func cacheFor(pool Pool, ctx context.Context, query sqrl.Sqlizer) (*Cache, CachedQuery, bool) {
+	cached, ok := query.(CachedQuery)
+	if !ok || pgxpool.UsesPrimary(ctx) {
+		return nil, cached, false
+	}
+	cache := findCache(pool)
+	return cache, cached, cache != nil
+}
+
+// findCache returns the cache of pool, if it has one.
+func findCache(pool Pool) *Cache {
+	for pool != nil {
+		switch p := pool.(type) {
+		case *CachedPool:
+			return p.cache
+		case interface{ Pool() Pool }:
+			pool = p.Pool()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Invalidate drops the cached results tagged with any of tags, when pool
+// has a cache, for writes it couldn't see, such as those of other instances.
+func Invalidate(pool Pool, tags ...string) {
+	if cache := findCache(pool); cache != nil {
+		cache.Invalidate(tags...)
+	}
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/elgris/sqrl"
+	"github.com/jackc/pgx/v4"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWrittenTables(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{name: "select", sql: "SELECT id FROM destination WHERE city = $1"},
+		{name: "select for update", sql: "SELECT id FROM destination WHERE id = $1 FOR UPDATE"},
+		{name: "insert", sql: "INSERT INTO destination (id) VALUES ($1)", want: []string{"destination"}},
+		{name: "upsert", sql: "INSERT INTO destination (id) VALUES ($1) ON CONFLICT (id) DO UPDATE SET city = excluded.city", want: []string{"destination"}},
+		{name: "update", sql: "UPDATE destination SET city = $1", want: []string{"destination"}},
+		{name: "update with alias", sql: "UPDATE destination AS d SET city = $1", want: []string{"destination"}},
+		{name: "delete", sql: "DELETE FROM destination WHERE id = $1", want: []string{"destination"}},
+		{name: "truncate", sql: "TRUNCATE TABLE destination", want: []string{"destination"}},
+		{name: "schema", sql: "INSERT INTO public.destination (id) VALUES ($1)", want: []string{"destination"}},
+		{name: "quoted", sql: `UPDATE ONLY "public"."Destination" SET city = $1`, want: []string{"destination"}},
+		{name: "lower case", sql: "delete from webhook where id = $1", want: []string{"webhook"}},
+		{
+			name: "several tables",
+			sql:  "WITH moved AS (DELETE FROM outbox RETURNING id) INSERT INTO outbox_archive SELECT * FROM moved",
+			want: []string{"outbox", "outbox_archive"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := writtenTables(test.sql); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("writtenTables() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// readingPool is a fakePool that reports whether each of its queries read
+// from the primary.
+func readingPool() (*fakePool, *[]bool) {
+	var mu sync.Mutex
+	primary := make([]bool, 0)
+	pool := &fakePool{}
+	pool.query = func(ctx context.Context, sql string, args []interface{}) (pgx.Rows, error) {
+		mu.Lock()
+		primary = append(primary, pgxpool.UsesPrimary(ctx))
+		mu.Unlock()
+		return &bufferedRows{result: &bufferedResult{}, index: -1}, nil
+	}
+	return pool, &primary
+}
+
+func cachedRows(t *testing.T, pool Pool, ctx context.Context) {
+	query := Cached(sqrl.Select("id").From("destination").Where("city = ?", "Paris"), time.Minute, "destination")
+	sql, args, err := query.ToSql()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := queryRows(pool, ctx, nil, query, sql, args)
+	if err != nil {
+		t.Fatalf("queryRows() error = %v", err)
+	}
+	rows.Close()
+}
+
+func TestCacheFillsFromPrimary(t *testing.T) {
+	fake, primary := readingPool()
+	pool := WithCache(fake, NewCache(CacheOptions{}))
+	ctx := context.Background()
+
+	cachedRows(t, pool, ctx)
+	cachedRows(t, pool, ctx)
+	if _, err := pool.Exec(ctx, "UPDATE destination SET city = $1", "Lyon"); err != nil {
+		t.Fatal(err)
+	}
+	cachedRows(t, pool, ctx)
+
+	if want := []bool{true, true}; !reflect.DeepEqual(*primary, want) {
+		t.Errorf("queries read from the primary: %v, want %v", *primary, want)
+	}
+	stats := pool.Cache().Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Invalidations != 1 {
+		t.Errorf("stats %+v, want a hit, two misses and an invalidation", stats)
+	}
+}
+
+func TestCacheDropsResultInvalidatedWhileFilling(t *testing.T) {
+	fake := &fakePool{}
+	cache := NewCache(CacheOptions{})
+	fake.query = func(ctx context.Context, sql string, args []interface{}) (pgx.Rows, error) {
+		cache.Invalidate("destination")
+		return &bufferedRows{result: &bufferedResult{}, index: -1}, nil
+	}
+	pool := WithCache(fake, cache)
+
+	cachedRows(t, pool, context.Background())
+	cachedRows(t, pool, context.Background())
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Misses != 2 || stats.Entries != 0 {
+		t.Errorf("stats %+v, want two misses and nothing cached", stats)
+	}
+}
+
+func TestCoalescingKeepsCacheFillsApart(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan bool, 4)
+	fake := &fakePool{}
+	fake.query = func(ctx context.Context, sql string, args []interface{}) (pgx.Rows, error) {
+		started <- pgxpool.UsesPrimary(ctx)
+		<-release
+		return &bufferedRows{result: &bufferedResult{}, index: -1}, nil
+	}
+	pool := Coalesce(fake)
+
+	contexts := []context.Context{
+		context.Background(),
+		context.Background(),
+		fillContext(context.Background()),
+		fillContext(context.Background()),
+	}
+	var wg sync.WaitGroup
+	for _, ctx := range contexts {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			rows, err := pool.Query(ctx, "SELECT id FROM destination")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			rows.Close()
+		}(ctx)
+	}
+
+	primary := map[bool]int{}
+	for i := 0; i < 2; i++ {
+		select {
+		case usesPrimary := <-started:
+			primary[usesPrimary]++
+		case <-time.After(5 * time.Second):
+			t.Fatal("the queries didn't start")
+		}
+	}
+	for pool.Stats().Queries < int64(len(contexts)) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if primary[true] != 1 || primary[false] != 1 || len(started) != 0 {
+		t.Errorf("ran %v queries by whether they read the primary, want one of each", primary)
+	}
+	if stats := pool.Stats(); stats.Coalesced != 2 {
+		t.Errorf("coalesced %d reads, want 2", stats.Coalesced)
+	}
+}
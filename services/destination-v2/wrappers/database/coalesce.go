@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	instana "github.com/instana/go-sensor"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalescingPool shares a single query between the callers that run the
+// same read, with the same arguments, while it is in flight. Each caller
+// waits for the result with its own context; the query runs until the
+// latest of their deadlines, and is cancelled once all of them have given
+// up. Statements that write, and contexts that
+// read from the primary, aren't coalesced, except for the reads that fill
+// the cache, which only share a query with each other.
+type CoalescingPool struct {
+	pool Pool
+
+	mu      sync.Mutex
+	flights map[string]*flight
+
+	queries, coalesced int64
+}
+
+// flightTimeout bounds a shared query for a caller that has no deadline.
+const flightTimeout = 20 * time.Second
+
+// flight is a query that is running for waiters callers.
+type flight struct {
+	done    chan struct{}
+	result  *bufferedResult
+	err     error
+	waiters int
+	ctx     *flightContext
+}
+
+func Coalesce(pool Pool) *CoalescingPool {
+	return &CoalescingPool{pool: pool, flights: make(map[string]*flight)}
+}
+
+// Pool returns the pool that p coalesces the queries of.
+func (p *CoalescingPool) Pool() Pool {
+	return p.pool
+}
+
+func (p *CoalescingPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return p.pool.Exec(ctx, sql, args...)
+}
+
+func (p *CoalescingPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if !coalescable(ctx, sql) {
+		return p.pool.Query(ctx, sql, args...)
+	}
+	result, err := p.do(ctx, sql, args)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedRows{result: result, index: -1}, nil
+}
+
+func (p *CoalescingPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if !coalescable(ctx, sql) {
+		return p.pool.QueryRow(ctx, sql, args...)
+	}
+	rows, err := p.Query(ctx, sql, args...)
+	return firstRow{rows: rows, err: err}
+}
+
+func (p *CoalescingPool) Begin(ctx context.Context) (pgxpool.Tx, error) {
+	return p.pool.Begin(ctx)
+}
+
+func (p *CoalescingPool) BeginTx(ctx context.Context, options pgx.TxOptions) (pgxpool.Tx, error) {
+	return p.pool.BeginTx(ctx, options)
+}
+
+type CoalesceStats struct {
+	Queries   int64 `json:"queries"`
+	Coalesced int64 `json:"coalesced"`
+	InFlight  int   `json:"inFlight"`
+}
+
+func (p *CoalescingPool) Stats() CoalesceStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return CoalesceStats{
+		Queries:   atomic.LoadInt64(&p.queries),
+		Coalesced: atomic.LoadInt64(&p.coalesced),
+		InFlight:  len(p.flights),
+	}
+}
+
+// do joins the flight of sql and args, or starts it, and waits for its
+// result. The span of ctx, if any, is tagged with whether the query was
+// coalesced into another caller's.
+func (p *CoalescingPool) do(ctx context.Context, sql string, args []interface{}) (*bufferedResult, error) {
+	key := cacheKey(sql, args)
+	if isCacheFill(ctx) {
+		key = "fill\x00" + key
+	}
+	atomic.AddInt64(&p.queries, 1)
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(flightTimeout)
+	}
+
+	p.mu.Lock()
+	f, joined := p.flights[key]
+	if joined && f.ctx.extend(deadline) {
+		f.waiters++
+	} else {
+		// The query outlives the caller that starts it when others are
+		// waiting for it, so it only keeps the values of its context.
+		joined = false
+		f = &flight{done: make(chan struct{}), waiters: 1, ctx: newFlightContext(ctx, deadline)}
+		p.flights[key] = f
+		go p.run(key, f, sql, args)
+	}
+	p.mu.Unlock()
+
+	if joined {
+		atomic.AddInt64(&p.coalesced, 1)
+	}
+	if span, ok := instana.SpanFromContext(ctx); ok {
+		span.SetTag("coalesced", joined)
+	}
+
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		p.leave(key, f)
+		return nil, ctx.Err()
+	}
+}
+
+func (p *CoalescingPool) run(key string, f *flight, sql string, args []interface{}) {
+	f.result, f.err = readResult(p.pool.Query(f.ctx, sql, args...))
+
+	p.mu.Lock()
+	if p.flights[key] == f {
+		delete(p.flights, key)
+	}
+	p.mu.Unlock()
+	f.ctx.cancel(context.Canceled)
+	close(f.done)
+}
+
+// leave gives up waiting for f, and cancels its query if nobody else is.
+func (p *CoalescingPool) leave(key string, f *flight) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if f.waiters--; f.waiters > 0 {
+		return
+	}
+	if p.flights[key] == f {
+		delete(p.flights, key)
+	}
+	f.ctx.cancel(context.Canceled)
+}
+
+func coalescable(ctx context.Context, sql string) bool {
+	return (!pgxpool.UsesPrimary(ctx) || isCacheFill(ctx)) && len(writtenTables(sql)) == 0
+}
+
+// flightContext is the context of a shared query. It keeps the values of
+// the context of the caller that started it, such as its span and route
+// class, but runs until the latest deadline of the callers waiting for it,
+// or until they have all given up.
+type flightContext struct {
+	parent context.Context
+	done   chan struct{}
+
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+	err      error
+}
+
+func newFlightContext(parent context.Context, deadline time.Time) *flightContext {
+	c := &flightContext{parent: parent, done: make(chan struct{}), deadline: deadline}
+	// The timer may fire before it is assigned, when the deadline has
+	// already passed.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer = time.AfterFunc(time.Until(deadline), c.expire)
+	return c
+}
+
+// extend pushes the deadline back to deadline, if it is later. It reports
+// false when the context is already done, and can't be waited for.
+func (c *flightContext) extend(deadline time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return false
+	}
+	if deadline.After(c.deadline) {
+		c.deadline = deadline
+		c.timer.Reset(time.Until(deadline))
+	}
+	return true
+}
+
+// expire ends the context, unless its deadline was extended in the
+// meantime: the timer then fires again.
+func (c *flightContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil && !time.Now().Before(c.deadline) {
+		c.end(context.DeadlineExceeded)
+	}
+}
+
+func (c *flightContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.end(err)
+	}
+}
+
+func (c *flightContext) end(err error) {
+	c.err = err
+	c.timer.Stop()
+	close(c.done)
+}
+
+func (c *flightContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline, true
+}
+
+func (c *flightContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *flightContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *flightContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgx/v4"
+	"testing"
+	"time"
+)
+
+// blockingPool is a fakePool whose queries wait for release, or for their
+// context to be done. Each query sends its context on started.
+func blockingPool() (*fakePool, chan context.Context, chan struct{}) {
+	started := make(chan context.Context, 10)
+	release := make(chan struct{})
+	pool := &fakePool{}
+	pool.query = func(ctx context.Context, sql string, args []interface{}) (pgx.Rows, error) {
+		started <- ctx
+		select {
+		case <-release:
+			return &bufferedRows{result: &bufferedResult{}, index: -1}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return pool, started, release
+}
+
+func receive(t *testing.T, started <-chan context.Context) context.Context {
+	select {
+	case ctx := <-started:
+		return ctx
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query didn't start")
+		return nil
+	}
+}
+
+// query runs a read through pool and sends its error on the returned channel.
+func query(pool *CoalescingPool, ctx context.Context, sql string) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		rows, err := pool.Query(ctx, sql)
+		if err == nil {
+			rows.Close()
+		}
+		errs <- err
+	}()
+	return errs
+}
+
+// waitQueries waits for pool to have counted n reads.
+func waitQueries(t *testing.T, pool *CoalescingPool, n int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stats().Queries < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d reads reached the pool, want %d", pool.Stats().Queries, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescingSharesQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     func() context.Context
+		sql     string
+		queries int
+	}{
+		{name: "identical reads", ctx: context.Background, sql: "SELECT id FROM destination", queries: 1},
+		{name: "writes", ctx: context.Background, sql: "DELETE FROM destination RETURNING id", queries: 3},
+		{
+			name:    "reads from the primary",
+			ctx:     func() context.Context { return pgxpool.WithPrimary(context.Background()) },
+			sql:     "SELECT id FROM destination",
+			queries: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, started, release := blockingPool()
+			pool := Coalesce(fake)
+
+			var errs []<-chan error
+			for i := 0; i < 3; i++ {
+				errs = append(errs, query(pool, test.ctx(), test.sql))
+			}
+			for i := 0; i < test.queries; i++ {
+				receive(t, started)
+			}
+			if test.queries == 1 {
+				waitQueries(t, pool, 3)
+			}
+			close(release)
+			for _, err := range errs {
+				if err := <-err; err != nil {
+					t.Fatalf("Query() error = %v", err)
+				}
+			}
+
+			if len(fake.statements) != test.queries {
+				t.Errorf("ran %d queries, want %d", len(fake.statements), test.queries)
+			}
+			if stats := pool.Stats(); test.queries == 1 && (stats.Coalesced != 2 || stats.InFlight != 0) {
+				t.Errorf("stats %+v, want 2 coalesced and none in flight", stats)
+			}
+		})
+	}
+}
+
+func TestCoalescingCancelsOnceEveryoneGaveUp(t *testing.T) {
+	fake, started, _ := blockingPool()
+	pool := Coalesce(fake)
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	firstErr := query(pool, first, "SELECT id FROM destination")
+	queryCtx := receive(t, started)
+	secondErr := query(pool, second, "SELECT id FROM destination")
+	waitQueries(t, pool, 2)
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first Query() error = %v, want its cancellation", err)
+	}
+	select {
+	case <-queryCtx.Done():
+		t.Fatal("the shared query was cancelled while a caller waited for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelSecond()
+	if err := <-secondErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("second Query() error = %v, want its cancellation", err)
+	}
+	select {
+	case <-queryCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shared query wasn't cancelled once every caller gave up")
+	}
+	if stats := pool.Stats(); stats.InFlight != 0 {
+		t.Errorf("%d flights left, want none", stats.InFlight)
+	}
+
+	// A caller that comes after starts its own query.
+	third, cancelThird := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelThird()
+	query(pool, third, "SELECT id FROM destination")
+	if ctx := receive(t, started); ctx == queryCtx {
+		t.Error("a new caller joined the cancelled query")
+	}
+}
+
+func TestCoalescingDeadline(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		deadlines []time.Duration
+		want      time.Duration
+	}{
+		{name: "single caller", deadlines: []time.Duration{time.Second}, want: time.Second},
+		{name: "later caller", deadlines: []time.Duration{time.Second, 3 * time.Second}, want: 3 * time.Second},
+		{name: "earlier caller", deadlines: []time.Duration{3 * time.Second, time.Second}, want: 3 * time.Second},
+		{name: "no deadline", deadlines: []time.Duration{0}, want: flightTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, started, release := blockingPool()
+			defer close(release)
+			pool := Coalesce(fake)
+
+			var queryCtx context.Context
+			for i, after := range test.deadlines {
+				ctx, cancel := context.Background(), context.CancelFunc(func() {})
+				if after > 0 {
+					ctx, cancel = context.WithDeadline(ctx, now.Add(after))
+				}
+				defer cancel()
+				query(pool, ctx, "SELECT id FROM destination")
+				if i == 0 {
+					queryCtx = receive(t, started)
+				}
+				waitQueries(t, pool, int64(i+1))
+			}
+
+			deadline, ok := queryCtx.Deadline()
+			if !ok {
+				t.Fatal("the shared query has no deadline")
+			}
+			if got := deadline.Sub(now); got < test.want || got > test.want+time.Second {
+				t.Errorf("shared query deadline in %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCoalescingTimesOut(t *testing.T) {
+	fake, started, release := blockingPool()
+	defer close(release)
+	pool := Coalesce(fake)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	errs := query(pool, ctx, "SELECT id FROM destination")
+	queryCtx := receive(t, started)
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Query() error = %v, want its deadline", err)
+	}
+	select {
+	case <-queryCtx.Done():
+		if err := queryCtx.Err(); err != context.DeadlineExceeded && err != context.Canceled {
+			t.Errorf("shared query error %v, want it to end", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shared query outlived its callers' deadline")
+	}
+}
+
+func TestFlightContext(t *testing.T) {
+	t.Run("expires at its deadline", func(t *testing.T) {
+		ctx := newFlightContext(context.Background(), time.Now().Add(20*time.Millisecond))
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("the context didn't expire")
+		}
+		if ctx.Err() != context.DeadlineExceeded {
+			t.Errorf("Err() = %v, want the deadline", ctx.Err())
+		}
+		if ctx.extend(time.Now().Add(time.Minute)) {
+			t.Error("an expired context was extended")
+		}
+	})
+
+	t.Run("extended", func(t *testing.T) {
+		ctx := newFlightContext(context.Background(), time.Now().Add(20*time.Millisecond))
+		if !ctx.extend(time.Now().Add(200 * time.Millisecond)) {
+			t.Fatal("the context couldn't be extended")
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("the context expired at its first deadline")
+		case <-time.After(100 * time.Millisecond):
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("the context didn't expire at its extended deadline")
+		}
+	})
+
+	t.Run("keeps the values of its parent", func(t *testing.T) {
+		parent := pgxpool.WithPrimary(context.Background())
+		ctx := newFlightContext(parent, time.Now().Add(time.Minute))
+		defer ctx.cancel(context.Canceled)
+		if !pgxpool.UsesPrimary(ctx) {
+			t.Error("the value of the parent context was lost")
+		}
+	})
+}
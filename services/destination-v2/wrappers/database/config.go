@@ -0,0 +1,470 @@
+package database
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	pgxpoolv4 "github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Config describes the database and how the pool connects to it.
+//
+// LoadConfig reads it from the environment. Every variable can instead be
+// read from the file named by the same variable with a _FILE suffix, the
+// way secrets are mounted.
+type Config struct {
+	// URL, from DATABASE_URL, replaces the host, port, user, password,
+	// database and TLS settings below.
+	URL string
+
+	Host     string
+	Port     int
+	User     string
+	Password string
+	// PasswordFile, from PG_PASSWORD_FILE, is read again for every new
+	// connection, so that a rotated password is used without a restart.
+	PasswordFile string
+	Database     string
+
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	ApplicationName  string
+	MaxConns         int32
+	MinConns         int32
+	MaxConnLifetime  time.Duration
+	MaxConnIdleTime  time.Duration
+	ConnectTimeout   time.Duration
+	StatementTimeout time.Duration
+	// SimpleProtocol avoids prepared statements, which PgBouncer can't
+	// share between clients in transaction pooling mode.
+	SimpleProtocol bool
+
+	// Replicas are the host:port of read replicas, which share the rest of
+	// the primary's settings.
+	Replicas []string
+	Options  pgxpool.Options
+	Retry    Retry
+	Guard    GuardOptions
+	// CoalesceQueries lets identical reads that run at once share a query.
+	CoalesceQueries bool
+	// CacheQueries enables the cache of CachedQuery results.
+	CacheQueries bool
+	Cache        CacheOptions
+}
+
+// Retry is how ConnectWithRetry waits between attempts. The backoff doubles
+// after every failed attempt, up to MaxBackoff, and each wait is drawn
+// between half of it and all of it, so that instances started together
+// don't retry in step. Timeout bounds the whole wait; zero retries until the
+// context is done.
+type Retry struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Timeout    time.Duration
+}
+
+// LoadConfig reads the configuration from the environment and validates it.
+func LoadConfig() (Config, error) {
+	env := &envReader{}
+	c := Config{
+		URL:      env.string("DATABASE_URL"),
+		Host:     env.string("PG_HOST"),
+		Port:     env.int("PG_PORT", 5432),
+		User:     env.string("PG_USER"),
+		Password: env.string("PG_PASSWORD"),
+		Database: env.string("PG_DATABASE"),
+
+		SSLMode:     env.string("PG_SSLMODE"),
+		SSLRootCert: env.string("PG_SSLROOTCERT"),
+		SSLCert:     env.string("PG_SSLCERT"),
+		SSLKey:      env.string("PG_SSLKEY"),
+
+		ApplicationName:  env.string("PG_APPLICATION_NAME"),
+		MaxConns:         int32(env.int("PG_POOL_MAX_CONNS", 0)),
+		MinConns:         int32(env.int("PG_POOL_MIN_CONNS", 0)),
+		MaxConnLifetime:  env.duration("PG_POOL_MAX_CONN_LIFETIME"),
+		MaxConnIdleTime:  env.duration("PG_POOL_MAX_CONN_IDLE_TIME"),
+		ConnectTimeout:   env.duration("PG_CONNECT_TIMEOUT"),
+		StatementTimeout: env.duration("PG_STATEMENT_TIMEOUT"),
+		SimpleProtocol:   env.bool("PG_SIMPLE_PROTOCOL"),
+	}
+	if _, found := os.LookupEnv("PG_PASSWORD"); !found {
+		c.PasswordFile = os.Getenv("PG_PASSWORD_FILE")
+	}
+	if c.Database == "" {
+		c.Database = "beetravels"
+	}
+
+	for _, address := range strings.Split(env.string("PG_REPLICA_HOSTS"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			c.Replicas = append(c.Replicas, address)
+		}
+	}
+	healthCheck := env.string("PG_HEALTH_CHECK")
+	balancer := env.string("PG_REPLICA_BALANCER")
+	c.Options.IdleCheckInterval = env.duration("PG_HEALTH_CHECK_INTERVAL")
+	c.Options.ReplicaCheckInterval = env.duration("PG_REPLICA_HEALTH_INTERVAL")
+	c.Retry = Retry{
+		Backoff:    env.duration("PG_CONNECT_BACKOFF"),
+		MaxBackoff: env.duration("PG_CONNECT_MAX_BACKOFF"),
+		Timeout:    env.duration("PG_CONNECT_RETRY_TIMEOUT"),
+	}
+	c.Guard = GuardOptions{
+		Breaker: BreakerOptions{
+			FailureRate:    env.float("PG_BREAKER_FAILURE_RATE"),
+			MinRequests:    env.int("PG_BREAKER_MIN_REQUESTS", 0),
+			Window:         env.duration("PG_BREAKER_WINDOW"),
+			OpenDuration:   env.duration("PG_BREAKER_OPEN_DURATION"),
+			HalfOpenProbes: env.int("PG_BREAKER_HALF_OPEN_PROBES", 0),
+		},
+		Bulkheads: map[RouteClass]int{
+			ReadRoutes:     env.int("PG_BULKHEAD_READ", 64),
+			WriteRoutes:    env.int("PG_BULKHEAD_WRITE", 32),
+			AdminRoutes:    env.int("PG_BULKHEAD_ADMIN", 8),
+			BackgroundWork: env.int("PG_BULKHEAD_BACKGROUND", 16),
+		},
+		BulkheadWait: env.duration("PG_BULKHEAD_WAIT"),
+	}
+	c.CoalesceQueries = env.bool("PG_QUERY_COALESCING")
+	c.CacheQueries = env.bool("PG_QUERY_CACHE")
+	c.Cache = CacheOptions{
+		Entries: env.int("PG_QUERY_CACHE_ENTRIES", 0),
+		TTL:     env.duration("PG_QUERY_CACHE_TTL"),
+	}
+	if c.Guard.BulkheadWait == 0 {
+		c.Guard.BulkheadWait = 500 * time.Millisecond
+	}
+	if c.Retry.Backoff == 0 {
+		c.Retry.Backoff = time.Second
+	}
+	if c.Retry.MaxBackoff == 0 {
+		c.Retry.MaxBackoff = 30 * time.Second
+	}
+	if env.err != nil {
+		return c, env.err
+	}
+
+	var err error
+	if c.Options.HealthCheck, err = pgxpool.ParseHealthCheck(healthCheck); err != nil {
+		return c, err
+	}
+	if c.Options.Balancer, err = pgxpool.ParseBalancer(balancer); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// Validate checks the configuration, so that mistakes stop the service at
+// startup rather than on its first query.
+func (c Config) Validate() error {
+	if c.URL != "" {
+		if _, err := pgxpoolv4.ParseConfig(c.URL); err != nil {
+			return errors.Errorf("invalid DATABASE_URL: %v", err)
+		}
+	} else {
+		for _, required := range []struct{ key, value string }{
+			{"PG_HOST", c.Host},
+			{"PG_USER", c.User},
+		} {
+			if required.value == "" {
+				return errors.Errorf("%s must be set", required.key)
+			}
+		}
+		if c.Password == "" && c.PasswordFile == "" {
+			return errors.Errorf("PG_PASSWORD must be set")
+		}
+		if c.Port < 1 || c.Port > 65535 {
+			return errors.Errorf("PG_PORT %d is out of range", c.Port)
+		}
+		if c.SSLMode != "" && !contains(sslModes, c.SSLMode) {
+			return errors.Errorf("invalid PG_SSLMODE %q, expected one of %s", c.SSLMode, strings.Join(sslModes, ", "))
+		}
+		if (c.SSLCert == "") != (c.SSLKey == "") {
+			return errors.Errorf("PG_SSLCERT and PG_SSLKEY must be set together")
+		}
+		for _, file := range []string{c.SSLRootCert, c.SSLCert, c.SSLKey, c.PasswordFile} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.MaxConns < 0 || c.MinConns < 0 {
+		return errors.Errorf("pool sizes can't be negative")
+	}
+	if c.MaxConns > 0 && c.MinConns > c.MaxConns {
+		return errors.Errorf("PG_POOL_MIN_CONNS %d exceeds PG_POOL_MAX_CONNS %d", c.MinConns, c.MaxConns)
+	}
+	for _, duration := range []time.Duration{c.MaxConnLifetime, c.MaxConnIdleTime, c.ConnectTimeout, c.StatementTimeout, c.Retry.Backoff, c.Retry.MaxBackoff, c.Retry.Timeout} {
+		if duration < 0 {
+			return errors.Errorf("durations can't be negative")
+		}
+	}
+	if rate := c.Guard.Breaker.FailureRate; rate < 0 || rate > 1 {
+		return errors.Errorf("PG_BREAKER_FAILURE_RATE %v must be between 0 and 1", rate)
+	}
+	for _, duration := range []time.Duration{c.Guard.Breaker.Window, c.Guard.Breaker.OpenDuration, c.Guard.BulkheadWait} {
+		if duration < 0 {
+			return errors.Errorf("durations can't be negative")
+		}
+	}
+	if c.Cache.Entries < 0 || c.Cache.TTL < 0 {
+		return errors.Errorf("PG_QUERY_CACHE_ENTRIES and PG_QUERY_CACHE_TTL can't be negative")
+	}
+	for class, limit := range c.Guard.Bulkheads {
+		if limit < 0 {
+			return errors.Errorf("the %s bulkhead can't be negative", class)
+		}
+	}
+	if c.Retry.Backoff > c.Retry.MaxBackoff {
+		return errors.Errorf("PG_CONNECT_BACKOFF %v exceeds PG_CONNECT_MAX_BACKOFF %v", c.Retry.Backoff, c.Retry.MaxBackoff)
+	}
+	for _, address := range c.Replicas {
+		if _, port, err := net.SplitHostPort(address); err != nil {
+			return errors.Errorf("invalid replica %q in PG_REPLICA_HOSTS: %v", address, err)
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return errors.Errorf("invalid replica %q in PG_REPLICA_HOSTS: bad port", address)
+		}
+	}
+	return nil
+}
+
+// PoolConfig builds the configuration of the primary's pool.
+func (c Config) PoolConfig() (*pgxpoolv4.Config, error) {
+	connString := c.URL
+	if connString == "" {
+		settings := [][2]string{
+			{"host", c.Host},
+			{"port", strconv.Itoa(c.Port)},
+			{"user", c.User},
+			{"dbname", c.Database},
+			{"sslmode", c.SSLMode},
+			{"sslrootcert", c.SSLRootCert},
+			{"sslcert", c.SSLCert},
+			{"sslkey", c.SSLKey},
+		}
+		parts := make([]string, 0, len(settings))
+		for _, setting := range settings {
+			if setting[1] != "" {
+				parts = append(parts, setting[0]+"="+quoteSetting(setting[1]))
+			}
+		}
+		connString = strings.Join(parts, " ")
+	}
+
+	config, err := pgxpoolv4.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	if c.URL == "" {
+		config.ConnConfig.Password = c.Password
+	}
+	if c.PasswordFile != "" {
+		file := c.PasswordFile
+		config.BeforeConnect = func(_ context.Context, connConfig *pgx.ConnConfig) error {
+			password, err := readSecret(file)
+			connConfig.Password = password
+			return err
+		}
+	}
+
+	if c.MaxConns > 0 {
+		config.MaxConns = c.MaxConns
+	}
+	if c.MinConns > 0 {
+		config.MinConns = c.MinConns
+	}
+	if c.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = c.MaxConnLifetime
+	}
+	if c.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = c.MaxConnIdleTime
+	}
+	if c.ConnectTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = c.ConnectTimeout
+	}
+	if c.ApplicationName != "" {
+		config.ConnConfig.RuntimeParams["application_name"] = c.ApplicationName
+	}
+	if c.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	config.ConnConfig.PreferSimpleProtocol = c.SimpleProtocol
+	return config, nil
+}
+
+// ReplicaConfigs derives the configuration of every replica's pool from the
+// primary's. Replicas connect lazily, so one that is down doesn't prevent
+// the service from starting. They keep the primary's TLS fallbacks, such as
+// the plain connection that sslmode=prefer tries after TLS, but not its
+// other hosts.
+func (c Config) ReplicaConfigs(primary *pgxpoolv4.Config) ([]*pgxpoolv4.Config, error) {
+	configs := make([]*pgxpoolv4.Config, 0, len(c.Replicas))
+	for _, address := range c.Replicas {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		portNumber, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+
+		config := primary.Copy()
+		config.LazyConnect = true
+		config.ConnConfig.Host = host
+		config.ConnConfig.Port = uint16(portNumber)
+		config.ConnConfig.TLSConfig = replicaTLS(primary.ConnConfig.TLSConfig, host)
+		config.ConnConfig.Fallbacks = make([]*pgconn.FallbackConfig, 0, len(primary.ConnConfig.Fallbacks))
+		for _, fallback := range primary.ConnConfig.Fallbacks {
+			if fallback.Host != primary.ConnConfig.Host || fallback.Port != primary.ConnConfig.Port {
+				continue
+			}
+			config.ConnConfig.Fallbacks = append(config.ConnConfig.Fallbacks, &pgconn.FallbackConfig{
+				Host:      host,
+				Port:      uint16(portNumber),
+				TLSConfig: replicaTLS(fallback.TLSConfig, host),
+			})
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// replicaTLS adapts the primary's TLS configuration, which may be nil, to
+// the replica at host.
+func replicaTLS(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		return nil
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// ConnConfig builds the configuration of a single connection to the
+// primary, outside of the pool.
+func (c Config) ConnConfig() (*pgx.ConnConfig, error) {
+	config, err := c.PoolConfig()
+	if err != nil {
+		return nil, err
+	}
+	connConfig := config.ConnConfig.Copy()
+	if config.BeforeConnect != nil {
+		if err := config.BeforeConnect(context.Background(), connConfig); err != nil {
+			return nil, err
+		}
+	}
+	return connConfig, nil
+}
+
+// envReader reads variables, or the content of the file named by the
+// variable with a _FILE suffix. It keeps the first error, after which it
+// reads nothing.
+type envReader struct {
+	err error
+}
+
+func (r *envReader) string(key string) string {
+	if r.err != nil {
+		return ""
+	}
+	if value, found := os.LookupEnv(key); found {
+		return value
+	}
+	if file, found := os.LookupEnv(key + "_FILE"); found {
+		var value string
+		value, r.err = readSecret(file)
+		return value
+	}
+	return ""
+}
+
+func (r *envReader) int(key string, fallback int) int {
+	value := r.string(key)
+	if value == "" || r.err != nil {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		r.err = errors.Errorf("invalid %s %q", key, value)
+	}
+	return n
+}
+
+func (r *envReader) duration(key string) time.Duration {
+	value := r.string(key)
+	if value == "" || r.err != nil {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		r.err = errors.Errorf("invalid %s %q", key, value)
+	}
+	return duration
+}
+
+func (r *envReader) bool(key string) bool {
+	value := r.string(key)
+	if value == "" || r.err != nil {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		r.err = errors.Errorf("invalid %s %q", key, value)
+	}
+	return b
+}
+
+func (r *envReader) float(key string) float64 {
+	value := r.string(key)
+	if value == "" || r.err != nil {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.err = errors.Errorf("invalid %s %q", key, value)
+	}
+	return f
+}
+
+func readSecret(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// quoteSetting quotes a value for a key/value connection string.
+func quoteSetting(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -7,40 +7,80 @@ import (
 	"github.com/elgris/sqrl"
 	instana "github.com/instana/go-sensor"
 	"github.com/pkg/errors"
-	"os"
+	"math/rand"
 	"time"
 )
 
 func NewDatabasePool(sensor *instana.Sensor) (Pool, error) {
-	host, found := os.LookupEnv("PG_HOST")
-	if !found {
-		return nil, errors.Errorf("PG_HOST must be set")
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return connect(sensor, config)
+}
+
+// ConnectWithRetry connects like NewDatabasePool, but keeps trying while
+// the database can't be reached, for services that start before it does.
+// Configuration errors are returned at once.
+func ConnectWithRetry(ctx context.Context, sensor *instana.Sensor) (Pool, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline time.Time
+	if config.Retry.Timeout > 0 {
+		deadline = time.Now().Add(config.Retry.Timeout)
 	}
-	port, found := os.LookupEnv("PG_PORT")
-	if !found {
-		return nil, errors.Errorf("PG_PORT must be set")
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	backoff := config.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		pool, err := connect(sensor, config)
+		if err == nil {
+			return pool, nil
+		}
+
+		wait := backoff/2 + time.Duration(random.Int63n(int64(backoff/2)+1))
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return nil, errors.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+		fmt.Printf("Database connection attempt %d failed, retrying in %v: %v\n", attempt, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > config.Retry.MaxBackoff {
+			backoff = config.Retry.MaxBackoff
+		}
 	}
-	user, found := os.LookupEnv("PG_USER")
-	if !found {
-		return nil, errors.Errorf("PG_USER must be set")
+}
+
+func connect(sensor *instana.Sensor, config Config) (Pool, error) {
+	poolConfig, err := config.PoolConfig()
+	if err != nil {
+		return nil, err
 	}
-	password, found := os.LookupEnv("PG_PASSWORD")
-	if !found {
-		return nil, errors.Errorf("PG_PASSWORD must be set")
+	replicaConfigs, err := config.ReplicaConfigs(poolConfig)
+	if err != nil {
+		return nil, err
 	}
-	connString := fmt.Sprintf(
-		"host=%s port=%s dbname=beetravels user=%s password=%s",
-		host, port, user, password,
-	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
 	defer cancel()
 
-	pool, err := pgxpool.Connect(sensor, ctx, connString)
+	pool, err := pgxpool.ConnectWithReplicas(sensor, ctx, poolConfig, replicaConfigs, config.Options)
 	if err != nil {
 		return nil, err
 	}
-	return pool, nil
+	var wrapped Pool = Guard(pool, config.Guard)
+	if config.CoalesceQueries {
+		wrapped = Coalesce(wrapped)
+	}
+	if config.CacheQueries {
+		wrapped = WithCache(wrapped, NewCache(config.Cache))
+	}
+	return wrapped, nil
 }
 
 func QueryBuilder() sqrl.StatementBuilderType {
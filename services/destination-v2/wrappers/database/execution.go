@@ -35,7 +35,7 @@ func QueryWithTimeout(pool Pool, ctx iris.Context, timeout time.Duration, query
 	stdCtx, span := contextWithChildSpan(sql, ctx.Request().Context())
 	stdCtx, cancel := context.WithTimeout(stdCtx, timeout)
 
-	rows, err := pool.Query(stdCtx, sql, args...)
+	rows, err := queryRows(pool, stdCtx, span, query, sql, args)
 	if err != nil {
 		cancel()
 		if err != pgx.ErrNoRows {
@@ -60,7 +60,7 @@ func QueryRowWithTimeout(pool Pool, ctx iris.Context, timeout time.Duration, que
 	stdCtx, span := contextWithChildSpan(sql, ctx.Request().Context())
 	stdCtx, cancel := context.WithTimeout(stdCtx, timeout)
 
-	row := pool.QueryRow(stdCtx, sql, args...)
+	row := queryRow(pool, stdCtx, span, query, sql, args)
 	return pgRowAdapter{span: span, row: row, args: args, cancel: cancel}
 }
 
@@ -101,7 +101,7 @@ func QueryFuncWithTimeout(pool Pool, ctx iris.Context, timeout time.Duration, qu
 	stdCtx, cancel := context.WithTimeout(stdCtx, timeout)
 	defer cancel()
 
-	rows, err := pool.Query(stdCtx, sql, args...)
+	rows, err := queryRows(pool, stdCtx, span, query, sql, args)
 	if err != nil {
 		span.SetTag("params", args)
 		return err
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"sync"
+)
+
+// fakePool records the statements and transactions run through it. query
+// answers Query and QueryRow; it returns no rows when nil.
+type fakePool struct {
+	mu         sync.Mutex
+	statements []string
+	txs        []*fakeTx
+	query      func(ctx context.Context, sql string, args []interface{}) (pgx.Rows, error)
+	commitErrs []error
+}
+
+func (p *fakePool) record(sql string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statements = append(p.statements, sql)
+}
+
+func (p *fakePool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	p.record(sql)
+	return pgconn.CommandTag("OK"), nil
+}
+
+func (p *fakePool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	p.record(sql)
+	if p.query == nil {
+		return &bufferedRows{result: &bufferedResult{}, index: -1}, nil
+	}
+	return p.query(ctx, sql, args)
+}
+
+func (p *fakePool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	rows, err := p.Query(ctx, sql, args...)
+	return firstRow{rows: rows, err: err}
+}
+
+func (p *fakePool) Begin(ctx context.Context) (pgxpool.Tx, error) {
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (p *fakePool) BeginTx(ctx context.Context, options pgx.TxOptions) (pgxpool.Tx, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx := &fakeTx{pool: p, options: options}
+	if len(p.commitErrs) > 0 {
+		tx.commitErr, p.commitErrs = p.commitErrs[0], p.commitErrs[1:]
+	}
+	p.txs = append(p.txs, tx)
+	return tx, nil
+}
+
+type fakeTx struct {
+	pool       *fakePool
+	options    pgx.TxOptions
+	commitErr  error
+	committed  bool
+	rolledBack bool
+}
+
+func (t *fakeTx) Commit(ctx context.Context) error {
+	if t.committed || t.rolledBack {
+		return pgx.ErrTxClosed
+	}
+	if t.commitErr != nil {
+		t.rolledBack = true
+		return t.commitErr
+	}
+	t.committed = true
+	return nil
+}
+
+func (t *fakeTx) Rollback(ctx context.Context) error {
+	if t.committed || t.rolledBack {
+		return pgx.ErrTxClosed
+	}
+	t.rolledBack = true
+	return nil
+}
+
+func (t *fakeTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return t.pool.Exec(ctx, sql, args...)
+}
+
+func (t *fakeTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return t.pool.Query(ctx, sql, args...)
+}
+
+func (t *fakeTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return t.pool.QueryRow(ctx, sql, args...)
+}
+
+func (t *fakeTx) Conn() *pgx.Conn {
+	return nil
+}
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UnavailableError is returned instead of running a statement while the
+// circuit breaker is open, or when too many statements of the same route
+// class are already running. Handlers answer it with a 503 and RetryAfter.
+type UnavailableError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return "database unavailable: " + e.Reason
+}
+
+// Unavailable returns the UnavailableError that err is or wraps, if any.
+func Unavailable(err error) (*UnavailableError, bool) {
+	for err != nil {
+		var unavailable *UnavailableError
+		if errors.As(err, &unavailable) {
+			return unavailable, true
+		}
+		// github.com/pkg/errors doesn't implement Unwrap before v0.9.
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return nil, false
+}
+
+// RouteClass groups the requests that share a bulkhead, so that a flood of
+// one kind can't take every connection from the others.
+type RouteClass string
+
+const (
+	ReadRoutes  RouteClass = "read"
+	WriteRoutes RouteClass = "write"
+	AdminRoutes RouteClass = "admin"
+	// BackgroundWork is the class of statements run outside of requests.
+	BackgroundWork RouteClass = "background"
+)
+
+type routeClassKey struct{}
+
+// WithRouteClass returns a context whose statements count against the
+// bulkhead of class.
+func WithRouteClass(ctx context.Context, class RouteClass) context.Context {
+	return context.WithValue(ctx, routeClassKey{}, class)
+}
+
+func routeClass(ctx context.Context) RouteClass {
+	if class, ok := ctx.Value(routeClassKey{}).(RouteClass); ok {
+		return class
+	}
+	return BackgroundWork
+}
+
+type GuardOptions struct {
+	Breaker BreakerOptions
+	// Bulkheads bounds the statements of each route class that run at once.
+	// A class that isn't listed, or is given zero, isn't bounded.
+	Bulkheads map[RouteClass]int
+	// BulkheadWait is how long a statement waits for a slot before it is
+	// refused.
+	BulkheadWait time.Duration
+}
+
+// GuardedPool runs the statements of a pool through a circuit breaker and
+// the bulkhead of their route class. Transactions hold their slot until
+// they are committed or rolled back.
+type GuardedPool struct {
+	pool      Pool
+	breaker   *Breaker
+	bulkheads map[RouteClass]*bulkhead
+}
+
+func Guard(pool Pool, options GuardOptions) *GuardedPool {
+	g := &GuardedPool{
+		pool:      pool,
+		breaker:   NewBreaker(options.Breaker),
+		bulkheads: make(map[RouteClass]*bulkhead),
+	}
+	for class, limit := range options.Bulkheads {
+		if limit > 0 {
+			g.bulkheads[class] = &bulkhead{class: class, slots: make(chan struct{}, limit), wait: options.BulkheadWait}
+		}
+	}
+	return g
+}
+
+// Pool returns the pool that g guards.
+func (g *GuardedPool) Pool() Pool {
+	return g.pool
+}
+
+// enter waits for a slot and asks the breaker. done must be called with the
+// outcome of the statement.
+func (g *GuardedPool) enter(ctx context.Context) (done func(err error), err error) {
+	release := func() {}
+	if b, ok := g.bulkheads[routeClass(ctx)]; ok {
+		if release, err = b.acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+	probe, err := g.breaker.allow()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() {
+			g.breaker.record(probe, err)
+			release()
+		})
+	}, nil
+}
+
+func (g *GuardedPool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	done, err := g.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := g.pool.Exec(ctx, sql, args...)
+	done(err)
+	return tag, err
+}
+
+func (g *GuardedPool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	done, err := g.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := g.pool.Query(ctx, sql, args...)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &guardedRows{Rows: rows, done: done}, nil
+}
+
+func (g *GuardedPool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	done, err := g.enter(ctx)
+	if err != nil {
+		return errorRow{err}
+	}
+	return guardedRow{row: g.pool.QueryRow(ctx, sql, args...), done: done}
+}
+
+func (g *GuardedPool) Begin(ctx context.Context) (pgxpool.Tx, error) {
+	return g.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (g *GuardedPool) BeginTx(ctx context.Context, options pgx.TxOptions) (pgxpool.Tx, error) {
+	done, err := g.enter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := g.pool.BeginTx(ctx, options)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return guardedTx{Tx: tx, done: done}, nil
+}
+
+type GuardStats struct {
+	Breaker   string                       `json:"breaker"`
+	Bulkheads map[RouteClass]BulkheadStats `json:"bulkheads"`
+}
+
+type BulkheadStats struct {
+	Limit    int   `json:"limit"`
+	InUse    int   `json:"inUse"`
+	Rejected int64 `json:"rejected"`
+}
+
+func (g *GuardedPool) Stats() GuardStats {
+	stats := GuardStats{
+		Breaker:   g.breaker.State().String(),
+		Bulkheads: make(map[RouteClass]BulkheadStats, len(g.bulkheads)),
+	}
+	for class, b := range g.bulkheads {
+		stats.Bulkheads[class] = BulkheadStats{
+			Limit:    cap(b.slots),
+			InUse:    len(b.slots),
+			Rejected: atomic.LoadInt64(&b.rejected),
+		}
+	}
+	return stats
+}
+
+type bulkhead struct {
+	class    RouteClass
+	slots    chan struct{}
+	wait     time.Duration
+	rejected int64
+}
+
+func (b *bulkhead) acquire(ctx context.Context) (release func(), err error) {
+	release = func() { <-b.slots }
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(b.wait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		atomic.AddInt64(&b.rejected, 1)
+		return nil, &UnavailableError{
+			Reason:     fmt.Sprintf("too many concurrent %s statements", b.class),
+			RetryAfter: time.Second,
+		}
+	}
+}
+
+type guardedRows struct {
+	pgx.Rows
+	done func(err error)
+}
+
+func (r *guardedRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.done(r.Rows.Err())
+	return false
+}
+
+func (r *guardedRows) Close() {
+	r.Rows.Close()
+	r.done(r.Rows.Err())
+}
+
+type guardedRow struct {
+	row  pgx.Row
+	done func(err error)
+}
+
+func (r guardedRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	r.done(err)
+	return err
+}
+
+type guardedTx struct {
+	pgxpool.Tx
+	done func(err error)
+}
+
+func (t guardedTx) Commit(ctx context.Context) error {
+	err := t.Tx.Commit(ctx)
+	t.done(err)
+	return err
+}
+
+func (t guardedTx) Rollback(ctx context.Context) error {
+	err := t.Tx.Rollback(ctx)
+	if errors.Is(err, pgx.ErrTxClosed) {
+		t.done(nil)
+	} else {
+		t.done(err)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+)
+
+// Notification is a payload received on a LISTEN channel.
+type Notification struct {
+	Channel string
+	Payload string
+}
+
+// Listener receives the notifications sent on a channel over a dedicated
+// connection. It can't use the pool: a pooled connection is shared, and
+// LISTEN only lasts as long as the session that ran it.
+type Listener struct {
+	channel string
+	config  Config
+}
+
+func NewListener(channel string) (*Listener, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{channel: channel, config: config}, nil
+}
+
+// Listen calls handle with every notification until ctx is done,
+// reconnecting when the connection drops. Notifications sent while it is
+// disconnected are lost, so onConnect runs once listening has resumed, for
+// the caller to catch up.
+func (l *Listener) Listen(ctx context.Context, onConnect func(ctx context.Context) error, handle func(notification Notification)) error {
+	backoff := minListenBackoff
+	for {
+		started := time.Now()
+		err := l.listen(ctx, onConnect, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(started) > maxListenBackoff {
+			backoff = minListenBackoff
+		}
+		fmt.Printf("listener on %s failed, retrying in %v: %v\n", l.channel, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxListenBackoff {
+			backoff = maxListenBackoff
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context, onConnect func(ctx context.Context) error, handle func(notification Notification)) error {
+	// The configuration is built again on every reconnect, to read a
+	// rotated password.
+	connConfig, err := l.config.ConnConfig()
+	if err != nil {
+		return err
+	}
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{l.channel}.Sanitize()); err != nil {
+		return err
+	}
+	if onConnect != nil {
+		if err := onConnect(ctx); err != nil {
+			return err
+		}
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(Notification{Channel: notification.Channel, Payload: notification.Payload})
+	}
+}
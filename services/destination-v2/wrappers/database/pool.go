@@ -14,4 +14,5 @@ type Pool interface {
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 	Begin(ctx context.Context) (pgxpool.Tx, error)
+	BeginTx(ctx context.Context, options pgx.TxOptions) (pgxpool.Tx, error)
 }
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	instana "github.com/instana/go-sensor"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/kataras/iris/v12"
+	"github.com/opentracing/opentracing-go/ext"
+	"math/rand"
+	"time"
+)
+
+// DefaultTxAttempts is how often a transaction runs before a serialization
+// failure is returned to the caller.
+const DefaultTxAttempts = 3
+
+type TxOptions struct {
+	IsoLevel   pgx.TxIsoLevel
+	AccessMode pgx.TxAccessMode
+	// MaxAttempts bounds the runs of a transaction that keeps failing with
+	// a serialization failure or a deadlock. Zero means DefaultTxAttempts.
+	MaxAttempts int
+}
+
+type TxFunction = func(tx pgxpool.Tx) error
+
+// WithTx runs fn in a transaction that is committed if fn returns nil and
+// rolled back if it returns an error or panics. fn may run more than once, so
+// it must not have side effects outside of tx.
+func WithTx(pool Pool, ctx iris.Context, options TxOptions, fn TxFunction) error {
+	return WithTxContext(pool, ctx.Request().Context(), options, fn)
+}
+
+func WithTxContext(pool Pool, ctx context.Context, options TxOptions, fn TxFunction) error {
+	attempts := options.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultTxAttempts
+	}
+
+	ctx, span := contextWithChildSpan("sql transaction", ctx)
+	if span != nil {
+		defer span.Finish()
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			// Back off a little, with jitter, so that the transactions that
+			// conflicted don't collide again straight away.
+			backoff := time.Duration(attempt*attempt) * 10 * time.Millisecond
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))):
+			}
+		}
+
+		err = runTx(pool, ctx, options, fn)
+		if span != nil {
+			span.SetTag("attempts", attempt)
+		}
+		if err == nil || !isRetryable(err) {
+			break
+		}
+	}
+
+	if err != nil && span != nil {
+		span.SetTag(string(ext.Error), fmt.Sprintf("%+v", err))
+	}
+	return err
+}
+
+func runTx(pool Pool, ctx context.Context, options TxOptions, fn TxFunction) (err error) {
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   options.IsoLevel,
+		AccessMode: options.AccessMode,
+	})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback(ctx)
+			panic(r)
+		}
+	}()
+
+	if err := fn(spanTx{tx: tx, ctx: ctx}); err != nil {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil && !errors.Is(rollbackErr, pgx.ErrTxClosed) {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// isRetryable reports serialization failures and deadlocks, after which
+// running the whole transaction again may succeed.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+// spanTx parents the spans of every statement run through it to the
+// transaction span, whatever context the caller passes.
+type spanTx struct {
+	tx  pgxpool.Tx
+	ctx context.Context
+}
+
+func (s spanTx) withSpan(ctx context.Context) context.Context {
+	if span, ok := instana.SpanFromContext(s.ctx); ok {
+		return instana.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+func (s spanTx) Commit(ctx context.Context) error {
+	return s.tx.Commit(s.withSpan(ctx))
+}
+
+func (s spanTx) Rollback(ctx context.Context) error {
+	return s.tx.Rollback(s.withSpan(ctx))
+}
+
+func (s spanTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return s.tx.Exec(s.withSpan(ctx), sql, args...)
+}
+
+func (s spanTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return s.tx.Query(s.withSpan(ctx), sql, args...)
+}
+
+func (s spanTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return s.tx.QueryRow(s.withSpan(ctx), sql, args...)
+}
+
+func (s spanTx) Conn() *pgx.Conn {
+	return s.tx.Conn()
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"testing"
+)
+
+func TestWithTxContext(t *testing.T) {
+	serialization := &pgconn.PgError{Code: "40001"}
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	violation := &pgconn.PgError{Code: "23505"}
+
+	tests := []struct {
+		name       string
+		options    TxOptions
+		fnErrs     []error
+		commitErrs []error
+		wantErr    error
+		wantTxs    []string
+	}{
+		{name: "commits", fnErrs: []error{nil}, wantTxs: []string{"committed"}},
+		{name: "rolls back on error", fnErrs: []error{violation}, wantErr: violation, wantTxs: []string{"rolled back"}},
+		{
+			name:    "retries serialization failures",
+			fnErrs:  []error{serialization, deadlock, nil},
+			wantTxs: []string{"rolled back", "rolled back", "committed"},
+		},
+		{
+			name:    "gives up after the attempts",
+			fnErrs:  []error{serialization, serialization, serialization, nil},
+			wantErr: serialization,
+			wantTxs: []string{"rolled back", "rolled back", "rolled back"},
+		},
+		{
+			name:    "MaxAttempts",
+			options: TxOptions{MaxAttempts: 1},
+			fnErrs:  []error{serialization},
+			wantErr: serialization,
+			wantTxs: []string{"rolled back"},
+		},
+		{
+			name:       "retries a commit that fails to serialize",
+			fnErrs:     []error{nil, nil},
+			commitErrs: []error{serialization},
+			wantTxs:    []string{"rolled back", "committed"},
+		},
+		{
+			name:    "isolation level",
+			options: TxOptions{IsoLevel: pgx.Serializable},
+			fnErrs:  []error{nil},
+			wantTxs: []string{"committed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := &fakePool{commitErrs: test.commitErrs}
+			runs := 0
+			err := WithTxContext(pool, context.Background(), test.options, func(tx pgxpool.Tx) error {
+				err := test.fnErrs[runs]
+				runs++
+				return err
+			})
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("error = %v, want %v", err, test.wantErr)
+			}
+			if len(pool.txs) != len(test.wantTxs) {
+				t.Fatalf("ran %d transactions, want %d", len(pool.txs), len(test.wantTxs))
+			}
+			for i, tx := range pool.txs {
+				state := "open"
+				switch {
+				case tx.committed:
+					state = "committed"
+				case tx.rolledBack:
+					state = "rolled back"
+				}
+				if state != test.wantTxs[i] {
+					t.Errorf("transaction %d is %s, want %s", i, state, test.wantTxs[i])
+				}
+				if tx.options.IsoLevel != test.options.IsoLevel {
+					t.Errorf("transaction %d has isolation %q, want %q", i, tx.options.IsoLevel, test.options.IsoLevel)
+				}
+			}
+		})
+	}
+}
+
+func TestWithTxContextRollsBackOnPanic(t *testing.T) {
+	pool := &fakePool{}
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("recovered %v, want the panic to propagate", r)
+		}
+		if len(pool.txs) != 1 || !pool.txs[0].rolledBack {
+			t.Error("the transaction wasn't rolled back")
+		}
+	}()
+	WithTxContext(pool, context.Background(), TxOptions{}, func(tx pgxpool.Tx) error {
+		panic("boom")
+	})
+}
+
+func TestWithTxContextStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &fakePool{}
+	err := WithTxContext(pool, ctx, TxOptions{}, func(tx pgxpool.Tx) error {
+		cancel()
+		return &pgconn.PgError{Code: "40001"}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+	if len(pool.txs) != 1 {
+		t.Errorf("ran %d transactions, want 1", len(pool.txs))
+	}
+}
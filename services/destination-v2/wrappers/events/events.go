@@ -0,0 +1,46 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Event is a versioned domain event. Type names what happened and Version
+// the schema of Data, so that consumers can keep reading older events as the
+// payload evolves.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	Subject    string          `json:"subject"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Publisher sends events. Implementations either accept every event or
+// return an error, in which case any of them may have been sent.
+type Publisher interface {
+	Publish(ctx context.Context, events ...Event) error
+}
+
+// New creates an event about subject, with a fresh id.
+func New(eventType string, version int, subject string, data interface{}) (Event, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return Event{}, err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:         id.String(),
+		Type:       eventType,
+		Version:    version,
+		Subject:    subject,
+		OccurredAt: time.Now().UTC(),
+		Data:       payload,
+	}, nil
+}
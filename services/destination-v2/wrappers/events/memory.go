@@ -0,0 +1,62 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultMemoryLimit is how many events the memory publisher of the service
+// keeps.
+const DefaultMemoryLimit = 1000
+
+// Memory keeps the last published events in memory, for tests and local
+// runs. Older events are dropped once it holds limit of them, so that a
+// long-running service doesn't grow without bound.
+type Memory struct {
+	mu      sync.Mutex
+	limit   int
+	events  []Event
+	dropped int64
+}
+
+func NewMemory(limit int) *Memory {
+	if limit <= 0 {
+		limit = DefaultMemoryLimit
+	}
+	return &Memory{limit: limit}
+}
+
+func (m *Memory) Publish(_ context.Context, events ...Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, events...)
+	if over := len(m.events) - m.limit; over > 0 {
+		// The slice is reallocated with only the kept events once append
+		// runs out of room, which frees the dropped ones.
+		m.events = m.events[over:]
+		m.dropped += int64(over)
+	}
+	return nil
+}
+
+// Events returns the events kept so far, in order.
+func (m *Memory) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+// Dropped returns how many events were dropped to respect the limit.
+func (m *Memory) Dropped() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dropped
+}
+
+// Reset forgets the events published so far.
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = nil
+	m.dropped = 0
+}
@@ -0,0 +1,56 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		published   int
+		wantFirst   string
+		wantKept    int
+		wantDropped int64
+	}{
+		{name: "under the limit", limit: 5, published: 3, wantFirst: "event-0", wantKept: 3},
+		{name: "at the limit", limit: 5, published: 5, wantFirst: "event-0", wantKept: 5},
+		{name: "over the limit", limit: 5, published: 12, wantFirst: "event-7", wantKept: 5, wantDropped: 7},
+		{name: "default limit", published: DefaultMemoryLimit + 1, wantFirst: "event-1", wantKept: DefaultMemoryLimit, wantDropped: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory := NewMemory(test.limit)
+			for i := 0; i < test.published; i++ {
+				memory.Publish(context.Background(), Event{ID: fmt.Sprintf("event-%d", i)})
+			}
+
+			kept := memory.Events()
+			if len(kept) != test.wantKept {
+				t.Fatalf("kept %d events, want %d", len(kept), test.wantKept)
+			}
+			if kept[0].ID != test.wantFirst {
+				t.Errorf("first event kept is %s, want %s", kept[0].ID, test.wantFirst)
+			}
+			if last := fmt.Sprintf("event-%d", test.published-1); kept[len(kept)-1].ID != last {
+				t.Errorf("last event kept is %s, want %s", kept[len(kept)-1].ID, last)
+			}
+			if memory.Dropped() != test.wantDropped {
+				t.Errorf("dropped %d events, want %d", memory.Dropped(), test.wantDropped)
+			}
+		})
+	}
+}
+
+func TestMemoryReset(t *testing.T) {
+	memory := NewMemory(1)
+	memory.Publish(context.Background(), Event{ID: "a"}, Event{ID: "b"})
+	memory.Reset()
+
+	if len(memory.Events()) != 0 || memory.Dropped() != 0 {
+		t.Errorf("after Reset, %d events kept and %d dropped, want none", len(memory.Events()), memory.Dropped())
+	}
+}
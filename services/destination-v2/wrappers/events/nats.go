@@ -0,0 +1,48 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes every event on <prefix>.<type>. The event id is sent as
+// Nats-Msg-Id, so that a JetStream stream on those subjects drops the
+// duplicates that at-least-once relaying can produce.
+type NATS struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func NewNATS(url, prefix, name string) (*NATS, error) {
+	// The relay keeps events in the outbox until the server is reachable,
+	// so there is no reason to give up on it.
+	conn, err := nats.Connect(url, nats.Name(name), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{conn: conn, prefix: prefix}, nil
+}
+
+// Publish returns once the server has received every event.
+func (n *NATS) Publish(ctx context.Context, events ...Event) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		msg := &nats.Msg{
+			Subject: n.prefix + "." + event.Type,
+			Header:  nats.Header{"Nats-Msg-Id": []string{event.ID}},
+			Data:    data,
+		}
+		if err := n.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
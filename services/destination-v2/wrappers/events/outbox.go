@@ -0,0 +1,212 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/elgris/sqrl"
+	"sort"
+	"time"
+)
+
+// OutboxTable stores events until they are relayed. Its columns are id
+// (bigserial), event_id, type, version, subject, payload, occurred_at,
+// published_at and claimed_until.
+const OutboxTable = "outbox_event"
+
+const (
+	relayBatchSize = 100
+	// relayLease is how long claimed events are hidden from other relays.
+	// Publishing gives up halfway through, so that the lease rarely ends
+	// while a batch is still being published.
+	relayLease = time.Minute
+)
+
+// claimEvents leases the oldest batch of unpublished events that no other
+// relay holds.
+const claimEvents = `
+	UPDATE outbox_event
+	SET claimed_until = now() + $2 * interval '1 millisecond'
+	WHERE id IN (
+		SELECT id FROM outbox_event
+		WHERE published_at IS NULL AND (claimed_until IS NULL OR claimed_until < now())
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, event_id, type, version, subject, payload, occurred_at`
+
+// Outbox is a Publisher that writes events in the transaction of the change
+// they describe, so that they are stored if and only if the change commits.
+// A Relay publishes them afterwards.
+type Outbox struct {
+	tx pgxpool.Tx
+}
+
+func NewOutbox(tx pgxpool.Tx) Outbox {
+	return Outbox{tx: tx}
+}
+
+func (o Outbox) Publish(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	insert := database.QueryBuilder().
+		Insert(OutboxTable).
+		Columns("event_id", "type", "version", "subject", "payload", "occurred_at")
+	for _, event := range events {
+		insert.Values(event.ID, event.Type, event.Version, event.Subject, []byte(event.Data), event.OccurredAt)
+	}
+
+	sql, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = o.tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// Relay publishes the events stored in the outbox to target, oldest first.
+// Delivery is at least once: events are only marked as published once target
+// accepted them. Several relays can share an outbox. Order isn't guaranteed:
+// relays publish their batches concurrently, and a batch that failed is
+// published again after later ones.
+type Relay struct {
+	pool   database.Pool
+	target Publisher
+}
+
+func NewRelay(pool database.Pool, target Publisher) *Relay {
+	return &Relay{pool: pool, target: target}
+}
+
+// Run relays events every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			relayed, err := r.RelayOnce(ctx)
+			if err != nil {
+				fmt.Printf("outbox relay failed: %v\n", err)
+				break
+			}
+			if relayed < relayBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes the oldest batch of unpublished events and returns its
+// size. The batch is leased in a statement of its own, so that no
+// transaction or row lock is held while target publishes it.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	ids, events, err := r.claim(ctx)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, relayLease/2)
+	err = r.target.Publish(publishCtx, events...)
+	cancel()
+	if err != nil {
+		// Let the next attempt take the batch without waiting for the lease.
+		r.mark(ctx, ids, database.QueryBuilder().Update(OutboxTable).Set("claimed_until", nil))
+		return 0, err
+	}
+
+	if err := r.mark(ctx, ids, database.QueryBuilder().Update(OutboxTable).Set("published_at", time.Now()).Set("claimed_until", nil)); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (r *Relay) claim(ctx context.Context) ([]int64, []Event, error) {
+	rows, err := r.pool.Query(ctx, claimEvents, relayBatchSize, relayLease.Milliseconds())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	type claimed struct {
+		id    int64
+		event Event
+	}
+	batch := make([]claimed, 0)
+	for rows.Next() {
+		var c claimed
+		var payload []byte
+		if err := rows.Scan(&c.id, &c.event.ID, &c.event.Type, &c.event.Version, &c.event.Subject, &payload, &c.event.OccurredAt); err != nil {
+			return nil, nil, err
+		}
+		c.event.Data = payload
+		batch = append(batch, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery.
+	sort.Slice(batch, func(i, j int) bool { return batch[i].id < batch[j].id })
+	ids := make([]int64, len(batch))
+	events := make([]Event, len(batch))
+	for i, c := range batch {
+		ids[i], events[i] = c.id, c.event
+	}
+	return ids, events, nil
+}
+
+// mark runs update on the events among ids.
+func (r *Relay) mark(ctx context.Context, ids []int64, update *sqrl.UpdateBuilder) error {
+	sql, args, err := update.Where("id = ANY(?)", ids).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = r.pool.Exec(ctx, sql, args...)
+	return err
+}
+
+// PruneResult counts the events that Prune deleted.
+type PruneResult struct {
+	Published int64
+	// Unpublished events were never relayed, because no publisher is
+	// configured or it kept failing.
+	Unpublished int64
+}
+
+// Prune deletes the events published before cutoff, and those that occurred
+// before it and are still unpublished: without a publisher, none ever is.
+func Prune(ctx context.Context, pool database.Pool, cutoff time.Time) (PruneResult, error) {
+	var result PruneResult
+	for _, prune := range []struct {
+		where   sqrl.Sqlizer
+		deleted *int64
+	}{
+		{sqrl.Expr("published_at < ?", cutoff), &result.Published},
+		{sqrl.Expr("published_at IS NULL AND occurred_at < ?", cutoff), &result.Unpublished},
+	} {
+		sql, args, err := database.QueryBuilder().
+			Delete().
+			From(OutboxTable).
+			Where(prune.where).
+			ToSql()
+		if err != nil {
+			return result, err
+		}
+		tag, err := pool.Exec(ctx, sql, args...)
+		if err != nil {
+			return result, err
+		}
+		*prune.deleted = tag.RowsAffected()
+	}
+	return result, nil
+}
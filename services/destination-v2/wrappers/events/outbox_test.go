@@ -0,0 +1,198 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePool answers the claim with claimed and records the other statements,
+// which affect the next of affected rows. It refuses transactions, which the
+// relay must not hold while publishing.
+type fakePool struct {
+	claimed  []outboxRow
+	affected []int64
+	execs    []string
+	args     [][]interface{}
+}
+
+type outboxRow struct {
+	id    int64
+	event Event
+}
+
+func (p *fakePool) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	p.execs = append(p.execs, sql)
+	p.args = append(p.args, args)
+	var affected int64
+	if len(p.affected) > 0 {
+		affected, p.affected = p.affected[0], p.affected[1:]
+	}
+	return pgconn.CommandTag(fmt.Sprintf("UPDATE %d", affected)), nil
+}
+
+func (p *fakePool) Query(_ context.Context, sql string, _ ...interface{}) (pgx.Rows, error) {
+	if sql != claimEvents {
+		return nil, fmt.Errorf("unexpected query %s", sql)
+	}
+	return &fakeRows{rows: p.claimed, index: -1}, nil
+}
+
+func (p *fakePool) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return nil
+}
+
+func (p *fakePool) Begin(ctx context.Context) (pgxpool.Tx, error) {
+	return nil, fmt.Errorf("no transaction expected")
+}
+
+func (p *fakePool) BeginTx(context.Context, pgx.TxOptions) (pgxpool.Tx, error) {
+	return nil, fmt.Errorf("no transaction expected")
+}
+
+type fakeRows struct {
+	rows  []outboxRow
+	index int
+}
+
+func (r *fakeRows) Close()                                         {}
+func (r *fakeRows) Err() error                                     { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                  { return nil }
+func (r *fakeRows) FieldDescriptions() []pgproto3.FieldDescription { return nil }
+func (r *fakeRows) Values() ([]interface{}, error)                 { return nil, nil }
+func (r *fakeRows) RawValues() [][]byte                            { return nil }
+
+func (r *fakeRows) Next() bool {
+	r.index++
+	return r.index < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	row := r.rows[r.index]
+	*dest[0].(*int64) = row.id
+	*dest[1].(*string) = row.event.ID
+	*dest[2].(*string) = row.event.Type
+	*dest[3].(*int) = row.event.Version
+	*dest[4].(*string) = row.event.Subject
+	*dest[5].(*[]byte) = row.event.Data
+	*dest[6].(*time.Time) = row.event.OccurredAt
+	return nil
+}
+
+// failingPublisher refuses every event.
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, ...Event) error {
+	return fmt.Errorf("publisher unavailable")
+}
+
+func outboxRows(ids ...int64) []outboxRow {
+	rows := make([]outboxRow, len(ids))
+	for i, id := range ids {
+		rows[i] = outboxRow{id: id, event: Event{ID: fmt.Sprintf("event-%d", id), Type: "destination.updated", Version: 1, Subject: "1", Data: []byte("{}")}}
+	}
+	return rows
+}
+
+func TestRelayOnce(t *testing.T) {
+	tests := []struct {
+		name      string
+		claimed   []outboxRow
+		target    Publisher
+		want      []string
+		wantErr   bool
+		wantMarks []string
+	}{
+		{
+			name: "nothing to relay",
+		},
+		{
+			name:      "published in id order",
+			claimed:   outboxRows(3, 1, 2),
+			target:    NewMemory(10),
+			want:      []string{"event-1", "event-2", "event-3"},
+			wantMarks: []string{"published_at"},
+		},
+		{
+			name:      "publisher failure releases the lease",
+			claimed:   outboxRows(1, 2),
+			target:    failingPublisher{},
+			wantErr:   true,
+			wantMarks: []string{"claimed_until"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := &fakePool{claimed: test.claimed}
+			target := test.target
+			if target == nil {
+				target = NewMemory(10)
+			}
+
+			relayed, err := NewRelay(pool, target).RelayOnce(context.Background())
+			if (err != nil) != test.wantErr {
+				t.Fatalf("RelayOnce() error = %v, want error: %v", err, test.wantErr)
+			}
+			if relayed != len(test.want) {
+				t.Errorf("RelayOnce() = %d, want %d", relayed, len(test.want))
+			}
+
+			if memory, ok := target.(*Memory); ok {
+				published := memory.Events()
+				if len(published) != len(test.want) {
+					t.Fatalf("published %d events, want %d", len(published), len(test.want))
+				}
+				for i, event := range published {
+					if event.ID != test.want[i] {
+						t.Errorf("event %d is %s, want %s", i, event.ID, test.want[i])
+					}
+				}
+			}
+
+			if len(pool.execs) != len(test.wantMarks) {
+				t.Fatalf("ran %q, want %d updates", pool.execs, len(test.wantMarks))
+			}
+			for i, mark := range test.wantMarks {
+				if !strings.Contains(pool.execs[i], mark) {
+					t.Errorf("update %q doesn't set %s", pool.execs[i], mark)
+				}
+				if strings.Contains(pool.execs[i], "published_at") && mark != "published_at" {
+					t.Errorf("update %q marks events as published", pool.execs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pool := &fakePool{affected: []int64{3, 2}}
+
+	result, err := Prune(context.Background(), pool, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (PruneResult{Published: 3, Unpublished: 2}) {
+		t.Errorf("Prune() = %+v, want 3 published and 2 unpublished", result)
+	}
+
+	wants := []string{"published_at < $1", "published_at IS NULL AND occurred_at < $1"}
+	if len(pool.execs) != len(wants) {
+		t.Fatalf("ran %q, want %d deletes", pool.execs, len(wants))
+	}
+	for i, want := range wants {
+		if !strings.HasPrefix(pool.execs[i], "DELETE FROM "+OutboxTable) || !strings.HasSuffix(pool.execs[i], "WHERE "+want) {
+			t.Errorf("statement %d is %q, want a delete where %s", i, pool.execs[i], want)
+		}
+		if len(pool.args[i]) != 1 || pool.args[i][0] != cutoff {
+			t.Errorf("statement %d has arguments %v, want the cutoff", i, pool.args[i])
+		}
+	}
+}
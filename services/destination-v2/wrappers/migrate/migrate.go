@@ -0,0 +1,263 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockKey identifies the advisory lock held while migrating. Any runner
+// against the same database waits for the others to finish.
+const lockKey = 7201880245231305
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+type Migrator struct {
+	pool       database.Pool
+	migrations []Migration
+}
+
+// New loads the migrations stored in fsys.
+func New(pool database.Pool, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// Load reads every <version>_<name>.up.sql file in the root of fsys, together
+// with its optional .down.sql counterpart, sorted by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	seen := make(map[int64]string)
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".up.sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 {
+			return nil, errors.Errorf("migration %s must be named <version>_<name>.up.sql", file)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, errors.Errorf("migrations %s and %s share version %d", other, file, version)
+		}
+		seen[version] = file
+
+		up, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		down, err := fs.ReadFile(fsys, base+".down.sql")
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    parts[1],
+			Up:      string(up),
+			Down:    string(down),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns the ones it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		done, err := m.apply(ctx, migration, func(tx pgxpool.Tx, isApplied bool) (bool, error) {
+			if isApplied {
+				return false, nil
+			}
+			if _, err := tx.Exec(ctx, migration.Up); err != nil {
+				return false, err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			return true, err
+		})
+		if err != nil {
+			return applied, errors.Wrapf(err, "migration %d_%s", migration.Version, migration.Name)
+		}
+		if done {
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the most recent steps applied migrations and returns the
+// ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	reverted := make([]Migration, 0)
+	for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := m.migrations[i]
+		done, err := m.apply(ctx, migration, func(tx pgxpool.Tx, isApplied bool) (bool, error) {
+			if !isApplied {
+				return false, nil
+			}
+			if strings.TrimSpace(migration.Down) == "" {
+				return false, errors.Errorf("no down migration, it can't be reverted")
+			}
+			if _, err := tx.Exec(ctx, migration.Down); err != nil {
+				return false, err
+			}
+			_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			return true, err
+		})
+		if err != nil {
+			return reverted, errors.Wrapf(err, "migration %d_%s", migration.Version, migration.Name)
+		}
+		if done {
+			reverted = append(reverted, migration)
+		}
+	}
+	return reverted, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+// Versions recorded in the database but unknown to this binary are listed
+// too, so a schema that is ahead of the code is visible.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if s, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = s.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, status := range applied {
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Check fails if any known migration has not been applied.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]string, 0)
+	for _, status := range statuses {
+		if !status.Applied {
+			pending = append(pending, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return errors.Errorf("schema is out of date, pending migrations: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// apply runs step in a transaction that holds the migration lock and tells
+// it whether migration is currently applied. Checking under the lock means a
+// runner that waited for another one sees its work.
+func (m *Migrator) apply(ctx context.Context, migration Migration, step func(tx pgxpool.Tx, isApplied bool) (bool, error)) (bool, error) {
+	var done bool
+	err := database.WithTxContext(m.pool, ctx, database.TxOptions{}, func(tx pgxpool.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", int64(lockKey)); err != nil {
+			return err
+		}
+		if err := ensureTable(ctx, tx); err != nil {
+			return err
+		}
+
+		var isApplied bool
+		err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", migration.Version).Scan(&isApplied)
+		if err != nil {
+			return err
+		}
+
+		done, err = step(tx, isApplied)
+		return err
+	})
+	return done && err == nil, err
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]Status, error) {
+	// A replica may not have caught up with the migrations just applied.
+	ctx = pgxpool.WithPrimary(ctx)
+
+	var exists bool
+	err := m.pool.QueryRow(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]Status)
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := m.pool.Query(ctx, "SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status Status
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		status.Applied = true
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
+
+func ensureTable(ctx context.Context, tx pgxpool.Tx) error {
+	_, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    bigint PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	return err
+}
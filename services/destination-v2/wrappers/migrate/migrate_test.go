@@ -0,0 +1,104 @@
+package migrate
+
+import (
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/migrations"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	file := func(content string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(content)}
+	}
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name: "sorted by version with optional down",
+			fsys: fstest.MapFS{
+				"10_add_index.up.sql":    file("CREATE INDEX"),
+				"2_add_column.up.sql":    file("ALTER TABLE ADD"),
+				"2_add_column.down.sql":  file("ALTER TABLE DROP"),
+				"1_create_table.up.sql":  file("CREATE TABLE"),
+				"README.md":              file("not a migration"),
+				"3_orphan_down.down.sql": file("ignored without an up"),
+			},
+			want: []Migration{
+				{Version: 1, Name: "create_table", Up: "CREATE TABLE"},
+				{Version: 2, Name: "add_column", Up: "ALTER TABLE ADD", Down: "ALTER TABLE DROP"},
+				{Version: 10, Name: "add_index", Up: "CREATE INDEX"},
+			},
+		},
+		{
+			name: "empty",
+			fsys: fstest.MapFS{},
+			want: []Migration{},
+		},
+		{
+			name:    "version isn't a number",
+			fsys:    fstest.MapFS{"first_create_table.up.sql": file("")},
+			wantErr: "must be named <version>_<name>.up.sql",
+		},
+		{
+			name:    "no name",
+			fsys:    fstest.MapFS{"1.up.sql": file("")},
+			wantErr: "must be named <version>_<name>.up.sql",
+		},
+		{
+			name: "shared version",
+			fsys: fstest.MapFS{
+				"1_create_table.up.sql": file(""),
+				"01_create_view.up.sql": file(""),
+			},
+			wantErr: "share version 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Load(test.fsys)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("loaded %d migrations, want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("migration %d = %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range loaded {
+		if migration.Version != int64(i+1) {
+			t.Errorf("migration %d_%s breaks the sequence at %d", migration.Version, migration.Name, i+1)
+		}
+		// The baseline adopts tables created before the migrations, which
+		// reverting it would drop.
+		if migration.Version == 1 && migration.Down != "" {
+			t.Errorf("the baseline migration must not have a down migration")
+		}
+		if migration.Version > 1 && strings.TrimSpace(migration.Down) == "" {
+			t.Errorf("migration %d_%s has no down migration", migration.Version, migration.Name)
+		}
+	}
+}
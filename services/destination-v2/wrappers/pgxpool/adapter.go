@@ -11,12 +11,38 @@ import (
 	ot "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"net"
+	"time"
 )
 
+// PrimaryNode names the primary in spans and stats; replicas are named by
+// host.
+const PrimaryNode = "primary"
+
+// Pool sends writes and transactions to the primary, and reads to its
+// replicas when it has any.
 type Pool struct {
 	sensor *instana.Sensor
 	config *pgxpool.Config
 	pool   *pgxpool.Pool
+
+	primary  *node
+	replicas []*node
+	balancer Balancer
+	next     uint32
+	health   HealthCheck
+	stop     context.CancelFunc
+}
+
+// Options configures the replicas and how connections are checked.
+type Options struct {
+	Balancer Balancer
+	// ReplicaCheckInterval is how often replicas are pinged. A replica that
+	// fails is skipped until it answers again.
+	ReplicaCheckInterval time.Duration
+	HealthCheck          HealthCheck
+	// IdleCheckInterval is how often idle connections are pinged by the
+	// background health check.
+	IdleCheckInterval time.Duration
 }
 
 type Tx interface {
@@ -33,29 +59,57 @@ func Connect(sensor *instana.Sensor, ctx context.Context, connString string) (*P
 	if err != nil {
 		return nil, err
 	}
-	config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
-		pid := conn.PgConn().PID()
-		if err := conn.Ping(ctx); err != nil {
-			fmt.Printf("Connection with PID %d seems bad, let's remove it from the pool\n", pid)
-			return false
-		}
-		return true
-	}
-
-	return ConnectConfig(sensor, ctx, config)
+	return connect(sensor, ctx, config, nil, Options{})
 }
 
 func ConnectConfig(sensor *instana.Sensor, ctx context.Context, config *pgxpool.Config) (*Pool, error) {
+	return connect(sensor, ctx, config, nil, Options{})
+}
+
+// ConnectWithReplicas connects to the primary described by config and to the
+// replicas, which serve Query and QueryRow. Replicas should connect lazily,
+// so that one that is down doesn't prevent the service from starting.
+func ConnectWithReplicas(sensor *instana.Sensor, ctx context.Context, config *pgxpool.Config, replicaConfigs []*pgxpool.Config, options Options) (*Pool, error) {
+	return connect(sensor, ctx, config, replicaConfigs, options)
+}
+
+func connect(sensor *instana.Sensor, ctx context.Context, config *pgxpool.Config, replicaConfigs []*pgxpool.Config, options Options) (*Pool, error) {
+	primary := newNode(PrimaryNode, config, options.HealthCheck)
 	pool, err := pgxpool.ConnectConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
+	primary.pool = pool
+
+	background, stop := context.WithCancel(context.Background())
+	p := &Pool{
+		sensor:   sensor,
+		config:   config,
+		pool:     pool,
+		primary:  primary,
+		balancer: options.Balancer,
+		health:   options.HealthCheck,
+		stop:     stop,
+	}
 
-	return &Pool{
-		sensor: sensor,
-		config: config,
-		pool:   pool,
-	}, nil
+	for _, replicaConfig := range replicaConfigs {
+		name := fmt.Sprintf("%s:%d", replicaConfig.ConnConfig.Host, replicaConfig.ConnConfig.Port)
+		r := newNode(name, replicaConfig, options.HealthCheck)
+		if r.pool, err = pgxpool.ConnectConfig(ctx, replicaConfig); err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.replicas = append(p.replicas, r)
+	}
+
+	if len(p.replicas) > 0 {
+		p.checkReplicas(ctx)
+		go p.runReplicaCheck(background, options.ReplicaCheckInterval)
+	}
+	if options.HealthCheck == BackgroundHealthCheck {
+		go p.runIdleCheck(background, options.IdleCheckInterval)
+	}
+	return p, nil
 }
 
 func ParseConfig(connString string) (*pgxpool.Config, error) {
@@ -67,14 +121,27 @@ func (p *Pool) PgxPool() *pgxpool.Pool {
 }
 
 func (p *Pool) Close() {
+	p.stop()
+	for _, r := range p.replicas {
+		r.pool.Close()
+	}
 	p.pool.Close()
 }
 
+func (p *Pool) nodes() []*node {
+	return append([]*node{p.primary}, p.replicas...)
+}
+
 func (p *Pool) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
-	childCtx, span := p.contextWithChildSpan(sql, ctx)
+	childCtx, span := p.contextWithChildSpan(sql, ctx, p.primary)
 	defer span.Finish()
 
-	tags, err := p.pool.Exec(childCtx, sql, args...)
+	conn, err := p.pool.Acquire(childCtx)
+	var tags pgconn.CommandTag
+	if err == nil {
+		tags, err = conn.Exec(childCtx, sql, args...)
+		p.primary.release(conn, err)
+	}
 	err = handleErr(err)
 	if err != nil {
 		span.SetTag(string(ext.Error), err.Error())
@@ -83,10 +150,15 @@ func (p *Pool) Exec(ctx context.Context, sql string, args ...interface{}) (pgcon
 }
 
 func (p *Pool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
-	childCtx, span := p.contextWithChildSpan(sql, ctx)
+	n := p.readNode(ctx)
+	childCtx, span := p.contextWithChildSpan(sql, ctx, n)
 	defer span.Finish()
 
-	rows, err := p.pool.Query(childCtx, sql, args...)
+	rows, err := n.query(childCtx, sql, args...)
+	if replicaFailed(n, err) {
+		span.SetTag("db.fallback", err.Error())
+		rows, err = p.primary.query(childCtx, sql, args...)
+	}
 	err = handleErr(err)
 	if err != nil {
 		span.SetTag(string(ext.Error), err.Error())
@@ -95,11 +167,18 @@ func (p *Pool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.
 }
 
 func (p *Pool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
-	childCtx, span := p.contextWithChildSpan(sql, ctx)
-	conn, err := p.pool.Acquire(ctx)
+	n := p.readNode(ctx)
+	childCtx, span := p.contextWithChildSpan(sql, ctx, n)
+	conn, err := n.pool.Acquire(ctx)
+	if replicaFailed(n, err) {
+		span.SetTag("db.fallback", err.Error())
+		n = p.primary
+		conn, err = n.pool.Acquire(ctx)
+	}
 	err = handleErr(err)
 	return &singleRow{
 		err:  err,
+		node: n,
 		conn: conn,
 		sql:  sql,
 		args: args,
@@ -108,16 +187,17 @@ func (p *Pool) QueryRow(ctx context.Context, sql string, args ...interface{}) pg
 	}
 }
 
-func (p *Pool) contextWithChildSpan(sql string, ctx context.Context) (context.Context, ot.Span) {
+// contextWithChildSpan starts the span of a statement sent to n.
+func (p *Pool) contextWithChildSpan(sql string, ctx context.Context, n *node) (context.Context, ot.Span) {
 	var spanOptions []ot.StartSpanOption
 	if parent, ok := instana.SpanFromContext(ctx); ok {
 		spanOptions = append(spanOptions, ot.ChildOf(parent.Context()))
 	}
 
-	host := p.config.ConnConfig.Host
-	port := p.config.ConnConfig.Port
-	user := p.config.ConnConfig.User
-	db := p.config.ConnConfig.Database
+	host := n.config.ConnConfig.Host
+	port := n.config.ConnConfig.Port
+	user := n.config.ConnConfig.User
+	db := n.config.ConnConfig.Database
 
 	span := p.sensor.Tracer().StartSpan(sql, spanOptions...)
 	span.SetTag(string(ext.SpanKind), string(ext.SpanKindRPCClientEnum))
@@ -126,14 +206,24 @@ func (p *Pool) contextWithChildSpan(sql string, ctx context.Context) (context.Co
 	span.SetTag(string(ext.DBUser), user)
 	span.SetTag(string(ext.DBStatement), sql)
 	span.SetTag(string(ext.PeerAddress), fmt.Sprintf("%s:%d", host, port))
+	span.SetTag("db.node", n.name)
 
 	return instana.ContextWithSpan(ctx, span), span
 }
 
 func (p *Pool) Begin(ctx context.Context) (Tx, error) {
-	t, err := p.pool.Begin(ctx)
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (p *Pool) BeginTx(ctx context.Context, options pgx.TxOptions) (Tx, error) {
+	childCtx, span := p.contextWithChildSpan("sql begin", ctx, p.primary)
+	defer span.Finish()
+
+	t, err := p.pool.BeginTx(childCtx, options)
+	err = handleErr(err)
 	if err != nil {
-		return nil, handleErr(err)
+		span.SetTag(string(ext.Error), err.Error())
+		return nil, err
 	}
 	return &tx{t: t, p: p}, nil
 }
@@ -144,10 +234,10 @@ type tx struct {
 }
 
 func (t *tx) Commit(ctx context.Context) error {
-	childCtx, span := t.p.contextWithChildSpan("sql commit", ctx)
+	childCtx, span := t.p.contextWithChildSpan("sql commit", ctx, t.p.primary)
 	defer span.Finish()
 
-	err := t.t.Rollback(childCtx)
+	err := t.t.Commit(childCtx)
 	err = handleErr(err)
 	if err != nil {
 		span.SetTag(string(ext.Error), err.Error())
@@ -156,7 +246,7 @@ func (t *tx) Commit(ctx context.Context) error {
 }
 
 func (t *tx) Rollback(ctx context.Context) error {
-	childCtx, span := t.p.contextWithChildSpan("sql rollback", ctx)
+	childCtx, span := t.p.contextWithChildSpan("sql rollback", ctx, t.p.primary)
 	defer span.Finish()
 
 	err := t.t.Rollback(childCtx)
@@ -168,7 +258,7 @@ func (t *tx) Rollback(ctx context.Context) error {
 }
 
 func (t *tx) Exec(ctx context.Context, sql string, args ...interface{}) (commandTag pgconn.CommandTag, err error) {
-	childCtx, span := t.p.contextWithChildSpan(sql, ctx)
+	childCtx, span := t.p.contextWithChildSpan(sql, ctx, t.p.primary)
 	defer span.Finish()
 
 	tags, err := t.t.Exec(childCtx, sql, args...)
@@ -180,7 +270,7 @@ func (t *tx) Exec(ctx context.Context, sql string, args ...interface{}) (command
 }
 
 func (t *tx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
-	childCtx, span := t.p.contextWithChildSpan(sql, ctx)
+	childCtx, span := t.p.contextWithChildSpan(sql, ctx, t.p.primary)
 	defer span.Finish()
 
 	rows, err := t.t.Query(childCtx, sql, args...)
@@ -192,7 +282,7 @@ func (t *tx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Ro
 }
 
 func (t *tx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
-	childCtx, span := t.p.contextWithChildSpan(sql, ctx)
+	childCtx, span := t.p.contextWithChildSpan(sql, ctx, t.p.primary)
 	defer span.Finish()
 
 	return rowWrapper{t.t.QueryRow(childCtx, sql, args...)}
@@ -202,6 +292,44 @@ func (t *tx) Conn() *pgx.Conn {
 	return t.t.Conn()
 }
 
+// query runs sql on a connection of n, which goes back to the pool when the
+// rows are closed or read to the end.
+func (n *node) query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	conn, err := n.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		n.release(conn, err)
+		return nil, err
+	}
+	return &connRows{Rows: rows, node: n, conn: conn}, nil
+}
+
+type connRows struct {
+	pgx.Rows
+	node     *node
+	conn     *pgxpool.Conn
+	released bool
+}
+
+func (r *connRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+func (r *connRows) Close() {
+	r.Rows.Close()
+	if !r.released {
+		r.released = true
+		r.node.release(r.conn, r.Rows.Err())
+	}
+}
+
 type rowWrapper struct {
 	row pgx.Row
 }
@@ -214,6 +342,7 @@ func (r rowWrapper) Scan(dest ...interface{}) error {
 
 type singleRow struct {
 	err  error
+	node *node
 	conn *pgxpool.Conn
 	sql  string
 	args []interface{}
@@ -223,15 +352,13 @@ type singleRow struct {
 
 func (s *singleRow) Scan(dest ...interface{}) error {
 	defer s.span.Finish()
-	defer s.conn.Release()
 	if s.err != nil {
 		return handleErr(s.err)
 	}
 	row := s.conn.QueryRow(s.ctx, s.sql, s.args...)
-	if err := row.Scan(dest...); err != nil {
-		return handleErr(err)
-	}
-	return nil
+	err := row.Scan(dest...)
+	s.node.release(s.conn, err)
+	return handleErr(err)
 }
 
 func handleErr(err error) error {
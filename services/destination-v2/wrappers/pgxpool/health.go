@@ -0,0 +1,283 @@
+package pgxpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck selects how broken connections are found.
+type HealthCheck int
+
+const (
+	// BackgroundHealthCheck pings idle connections periodically, and evicts
+	// connections whose statements fail with a connection error. Queries
+	// don't pay for it, but one may fail on a connection that broke since
+	// the last check.
+	BackgroundHealthCheck HealthCheck = iota
+	// AcquireHealthCheck pings every connection before handing it out, which
+	// costs a round trip per statement.
+	AcquireHealthCheck
+)
+
+func (h HealthCheck) String() string {
+	if h == AcquireHealthCheck {
+		return "acquire"
+	}
+	return "background"
+}
+
+// ParseHealthCheck reads "background" or "acquire".
+func ParseHealthCheck(name string) (HealthCheck, error) {
+	switch name {
+	case "", "background":
+		return BackgroundHealthCheck, nil
+	case "acquire":
+		return AcquireHealthCheck, nil
+	default:
+		return BackgroundHealthCheck, fmt.Errorf("unknown health check %q, expected background or acquire", name)
+	}
+}
+
+// ErrorClass tells what a failed statement means for its connection.
+type ErrorClass int
+
+const (
+	NoError ErrorClass = iota
+	// QueryError is an error of the statement; the connection is fine.
+	QueryError
+	// ConnectionError means the connection is unusable and must be evicted.
+	ConnectionError
+	// Canceled means the caller gave up or ran out of time.
+	Canceled
+)
+
+// Classify tells what err, returned by a statement, means for the
+// connection that ran it.
+func Classify(err error) ErrorClass {
+	switch {
+	case err == nil || err == pgx.ErrNoRows:
+		return NoError
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return Canceled
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"), // connection_exception
+			pgErr.Code == "57P01", // admin_shutdown
+			pgErr.Code == "57P02", // crash_shutdown
+			pgErr.Code == "57P03": // cannot_connect_now
+			return ConnectionError
+		}
+		return QueryError
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || pgconn.SafeToRetry(err) {
+		return ConnectionError
+	}
+	return QueryError
+}
+
+// node is the primary or one of the replicas.
+type node struct {
+	name    string
+	config  *pgxpool.Config
+	pool    *pgxpool.Pool
+	healthy int32
+	stats   nodeCounters
+}
+
+type nodeCounters struct {
+	pings             int64
+	pingNanos         int64
+	failedPings       int64
+	evictedAfterError int64
+}
+
+// newNode prepares the node that config connects to. With the acquire
+// strategy, its connections are pinged before every use.
+func newNode(name string, config *pgxpool.Config, health HealthCheck) *node {
+	n := &node{name: name, config: config, healthy: 1}
+	if health == AcquireHealthCheck {
+		config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+			if err := n.ping(ctx, conn); err != nil {
+				fmt.Printf("Connection with PID %d seems bad, let's remove it from the pool\n", conn.PgConn().PID())
+				return false
+			}
+			return true
+		}
+	}
+	return n
+}
+
+func (n *node) isHealthy() bool {
+	return atomic.LoadInt32(&n.healthy) == 1
+}
+
+func (n *node) setHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+	if atomic.SwapInt32(&n.healthy, value) != value {
+		fmt.Printf("Replica %s is now healthy: %t\n", n.name, healthy)
+	}
+}
+
+func (n *node) ping(ctx context.Context, conn *pgx.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := conn.Ping(ctx)
+	atomic.AddInt64(&n.stats.pings, 1)
+	atomic.AddInt64(&n.stats.pingNanos, int64(time.Since(start)))
+	if err != nil {
+		atomic.AddInt64(&n.stats.failedPings, 1)
+	}
+	return err
+}
+
+// checkIdle pings the connections that are idle and evicts those that
+// don't answer. The pings run at once, and each connection goes back to the
+// pool as soon as its own ping is over, so that the check holds the idle
+// connections for one ping rather than for all of them in turn.
+func (n *node) checkIdle(ctx context.Context) {
+	acquired := n.pool.AcquireAllIdle(ctx)
+	conns := make([]idleConn, len(acquired))
+	for i, conn := range acquired {
+		conns[i] = conn
+	}
+	pingIdle(conns, func(conn idleConn) error {
+		return n.ping(ctx, conn.Conn())
+	}, func(conn idleConn) {
+		fmt.Printf("Connection with PID %d seems bad, let's remove it from the pool\n", conn.Conn().PgConn().PID())
+		closeConn(conn.Conn())
+	})
+}
+
+// idleConn is the part of *pgxpool.Conn that the idle check uses.
+type idleConn interface {
+	Conn() *pgx.Conn
+	Release()
+}
+
+// pingIdle pings conns concurrently, evicts those whose ping fails and
+// releases each one when it is done with it.
+func pingIdle(conns []idleConn, ping func(conn idleConn) error, evict func(conn idleConn)) {
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn idleConn) {
+			defer wg.Done()
+			defer conn.Release()
+			if err := ping(conn); err != nil {
+				evict(conn)
+			}
+		}(conn)
+	}
+	wg.Wait()
+}
+
+// release returns conn to the pool, after closing it if err shows that it
+// is broken. The pool drops closed connections instead of reusing them.
+func (n *node) release(conn *pgxpool.Conn, err error) {
+	if Classify(err) == ConnectionError {
+		atomic.AddInt64(&n.stats.evictedAfterError, 1)
+		closeConn(conn.Conn())
+	}
+	conn.Release()
+}
+
+func closeConn(conn *pgx.Conn) {
+	if conn.IsClosed() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn.Close(ctx)
+}
+
+func (p *Pool) runIdleCheck(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, n := range p.nodes() {
+				n.checkIdle(ctx)
+			}
+		}
+	}
+}
+
+// Stats describes the pool's nodes, so that the cost of the health check
+// strategies can be compared: with the acquire strategy every acquire
+// includes a ping, with the background one only idle connections are pinged.
+type Stats struct {
+	HealthCheck string      `json:"healthCheck"`
+	Nodes       []NodeStats `json:"nodes"`
+}
+
+type NodeStats struct {
+	Node              string  `json:"node"`
+	Healthy           bool    `json:"healthy"`
+	TotalConns        int32   `json:"totalConns"`
+	IdleConns         int32   `json:"idleConns"`
+	AcquiredConns     int32   `json:"acquiredConns"`
+	Acquires          int64   `json:"acquires"`
+	AverageAcquireMs  float64 `json:"averageAcquireMs"`
+	EmptyAcquires     int64   `json:"emptyAcquires"`
+	Pings             int64   `json:"pings"`
+	AveragePingMs     float64 `json:"averagePingMs"`
+	FailedPings       int64   `json:"failedPings"`
+	EvictedAfterError int64   `json:"evictedAfterError"`
+}
+
+func (p *Pool) Stats() Stats {
+	stats := Stats{HealthCheck: p.health.String(), Nodes: make([]NodeStats, 0, len(p.replicas)+1)}
+	for _, n := range p.nodes() {
+		stat := n.pool.Stat()
+		pings := atomic.LoadInt64(&n.stats.pings)
+		stats.Nodes = append(stats.Nodes, NodeStats{
+			Node:              n.name,
+			Healthy:           n.isHealthy(),
+			TotalConns:        stat.TotalConns(),
+			IdleConns:         stat.IdleConns(),
+			AcquiredConns:     stat.AcquiredConns(),
+			Acquires:          stat.AcquireCount(),
+			AverageAcquireMs:  averageMs(int64(stat.AcquireDuration()), stat.AcquireCount()),
+			EmptyAcquires:     stat.EmptyAcquireCount(),
+			Pings:             pings,
+			AveragePingMs:     averageMs(atomic.LoadInt64(&n.stats.pingNanos), pings),
+			FailedPings:       atomic.LoadInt64(&n.stats.failedPings),
+			EvictedAfterError: atomic.LoadInt64(&n.stats.evictedAfterError),
+		})
+	}
+	return stats
+}
+
+func averageMs(totalNanos, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(totalNanos) / float64(count) / float64(time.Millisecond)
+}
@@ -0,0 +1,126 @@
+package pgxpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	name    string
+	delay   time.Duration
+	fails   bool
+	evicted bool
+
+	mu       sync.Mutex
+	released bool
+}
+
+func (c *fakeConn) Conn() *pgx.Conn {
+	return nil
+}
+
+func (c *fakeConn) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.released = true
+}
+
+func (c *fakeConn) isReleased() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.released
+}
+
+func TestPingIdle(t *testing.T) {
+	fast := &fakeConn{name: "fast"}
+	broken := &fakeConn{name: "broken", fails: true}
+	slow := &fakeConn{name: "slow", delay: time.Second}
+
+	// While the slow connection is being pinged, the fast one must already
+	// be back in the pool.
+	slowPinging := make(chan struct{})
+	fastReleasedEarly := make(chan bool, 1)
+	go func() {
+		<-slowPinging
+		deadline := time.Now().Add(500 * time.Millisecond)
+		for time.Now().Before(deadline) && !fast.isReleased() {
+			time.Sleep(5 * time.Millisecond)
+		}
+		fastReleasedEarly <- fast.isReleased() && !slow.isReleased()
+	}()
+
+	start := time.Now()
+	pingIdle([]idleConn{slow, fast, broken}, func(conn idleConn) error {
+		c := conn.(*fakeConn)
+		if c == slow {
+			close(slowPinging)
+		}
+		time.Sleep(c.delay)
+		if c.fails {
+			return fmt.Errorf("%s doesn't answer", c.name)
+		}
+		return nil
+	}, func(conn idleConn) {
+		conn.(*fakeConn).evicted = true
+	})
+
+	if elapsed := time.Since(start); elapsed > 1900*time.Millisecond {
+		t.Errorf("pings took %v, want them to run at once", elapsed)
+	}
+	if !<-fastReleasedEarly {
+		t.Error("the fast connection was held until the slow ping ended")
+	}
+	for _, conn := range []*fakeConn{fast, broken, slow} {
+		if !conn.isReleased() {
+			t.Errorf("%s wasn't released", conn.name)
+		}
+		if conn.evicted != conn.fails {
+			t.Errorf("%s evicted: %v, want %v", conn.name, conn.evicted, conn.fails)
+		}
+	}
+}
+
+func TestPingIdleWithoutConnections(t *testing.T) {
+	pingIdle(nil, func(idleConn) error {
+		t.Error("pinged a connection that doesn't exist")
+		return nil
+	}, func(idleConn) {})
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "no error", err: nil, want: NoError},
+		{name: "no rows", err: pgx.ErrNoRows, want: NoError},
+		{name: "canceled", err: context.Canceled, want: Canceled},
+		{name: "timed out", err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: Canceled},
+		{name: "constraint violation", err: &pgconn.PgError{Code: "23505"}, want: QueryError},
+		{name: "statement timeout", err: &pgconn.PgError{Code: "57014"}, want: QueryError},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: ConnectionError},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: ConnectionError},
+		{name: "crash shutdown", err: &pgconn.PgError{Code: "57P02"}, want: ConnectionError},
+		{name: "starting up", err: &pgconn.PgError{Code: "57P03"}, want: ConnectionError},
+		{name: "network error", err: &net.OpError{Op: "read", Err: errors.New("connection reset")}, want: ConnectionError},
+		{name: "connection closed", err: fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), want: ConnectionError},
+		{name: "other error", err: errors.New("cannot scan"), want: QueryError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Classify(test.err); got != test.want {
+				t.Errorf("Classify(%v) = %d, want %d", test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package pgxpool
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Balancer chooses which healthy replica serves a read.
+type Balancer int
+
+const (
+	RoundRobin Balancer = iota
+	LeastConnections
+)
+
+// ParseBalancer reads "round-robin" or "least-connections".
+func ParseBalancer(name string) (Balancer, error) {
+	switch name {
+	case "", "round-robin":
+		return RoundRobin, nil
+	case "least-connections":
+		return LeastConnections, nil
+	default:
+		return RoundRobin, fmt.Errorf("unknown balancer %q, expected round-robin or least-connections", name)
+	}
+}
+
+type primaryKey struct{}
+
+// WithPrimary returns a context whose reads go to the primary, for callers
+// that must read their own writes or can't tolerate replication lag.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary reports whether ctx was made by WithPrimary.
+func UsesPrimary(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryKey{}).(bool)
+	return forced
+}
+
+func (p *Pool) runReplicaCheck(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkReplicas(ctx)
+		}
+	}
+}
+
+func (p *Pool) checkReplicas(ctx context.Context) {
+	for _, r := range p.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		r.setHealthy(r.pool.Ping(pingCtx) == nil)
+		cancel()
+	}
+}
+
+// readNode picks the node that serves a read: a healthy replica unless ctx
+// asks for the primary, or none is healthy.
+func (p *Pool) readNode(ctx context.Context) *node {
+	if len(p.replicas) == 0 || UsesPrimary(ctx) {
+		return p.primary
+	}
+
+	start := int(atomic.AddUint32(&p.next, 1))
+	var chosen *node
+	for i := range p.replicas {
+		r := p.replicas[(start+i)%len(p.replicas)]
+		if !r.isHealthy() {
+			continue
+		}
+		if p.balancer == RoundRobin {
+			chosen = r
+			break
+		}
+		if chosen == nil || r.pool.Stat().AcquiredConns() < chosen.pool.Stat().AcquiredConns() {
+			chosen = r
+		}
+	}
+	if chosen == nil {
+		return p.primary
+	}
+	return chosen
+}
+
+// replicaFailed reports whether err means that the replica n couldn't be
+// reached, in which case it is marked unhealthy and the read can be sent to
+// the primary. Errors of the query itself, and cancellations, don't count.
+func replicaFailed(n *node, err error) bool {
+	if n.name == PrimaryNode || Classify(err) != ConnectionError {
+		return false
+	}
+	n.setHealthy(false)
+	return true
+}
@@ -0,0 +1,130 @@
+package pgxpool
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"io"
+	"testing"
+)
+
+// lazyNode is a node whose pool never connects, which is enough to count
+// its acquired connections.
+func lazyNode(t *testing.T, name string, healthy bool) *node {
+	config, err := pgxpool.ParseConfig("host=127.0.0.1 port=1 user=test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.LazyConnect = true
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	n := &node{name: name, config: config, pool: pool}
+	n.setHealthy(healthy)
+	return n
+}
+
+func TestParseBalancer(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Balancer
+		wantErr bool
+	}{
+		{name: "", want: RoundRobin},
+		{name: "round-robin", want: RoundRobin},
+		{name: "least-connections", want: LeastConnections},
+		{name: "random", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseBalancer(test.name)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseBalancer(%q) error = %v, want error: %v", test.name, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseBalancer(%q) = %v, want %v", test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		balancer Balancer
+		healthy  []bool
+		primary  bool
+		reads    int
+		want     []string
+	}{
+		{name: "no replicas", reads: 2, want: []string{PrimaryNode, PrimaryNode}},
+		{name: "round robin", healthy: []bool{true, true}, reads: 4, want: []string{"replica-1", "replica-0", "replica-1", "replica-0"}},
+		{name: "skips unhealthy replicas", healthy: []bool{true, false, true}, reads: 3, want: []string{"replica-2", "replica-2", "replica-0"}},
+		{name: "falls back to the primary", healthy: []bool{false, false}, reads: 2, want: []string{PrimaryNode, PrimaryNode}},
+		{name: "asked for the primary", healthy: []bool{true}, primary: true, reads: 1, want: []string{PrimaryNode}},
+		{name: "least connections", balancer: LeastConnections, healthy: []bool{false, true}, reads: 2, want: []string{"replica-1", "replica-1"}},
+		{name: "least connections without healthy replicas", balancer: LeastConnections, healthy: []bool{false}, reads: 1, want: []string{PrimaryNode}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Pool{primary: lazyNode(t, PrimaryNode, true), balancer: test.balancer}
+			for i, healthy := range test.healthy {
+				p.replicas = append(p.replicas, lazyNode(t, fmt.Sprintf("replica-%d", i), healthy))
+			}
+			ctx := context.Background()
+			if test.primary {
+				ctx = WithPrimary(ctx)
+			}
+
+			for i := 0; i < test.reads; i++ {
+				if got := p.readNode(ctx).name; got != test.want[i] {
+					t.Errorf("read %d went to %s, want %s", i, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReplicaFailed(t *testing.T) {
+	tests := []struct {
+		name        string
+		node        string
+		err         error
+		want        bool
+		wantHealthy bool
+	}{
+		{name: "connection lost", node: "replica-0", err: io.EOF, want: true},
+		{name: "replica shutting down", node: "replica-0", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "query error", node: "replica-0", err: &pgconn.PgError{Code: "42P01"}, wantHealthy: true},
+		{name: "canceled", node: "replica-0", err: context.Canceled, wantHealthy: true},
+		{name: "primary", node: PrimaryNode, err: io.EOF, wantHealthy: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := &node{name: test.node}
+			n.setHealthy(true)
+			if got := replicaFailed(n, test.err); got != test.want {
+				t.Errorf("replicaFailed() = %v, want %v", got, test.want)
+			}
+			if n.isHealthy() != test.wantHealthy {
+				t.Errorf("healthy = %v, want %v", n.isHealthy(), test.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestUsesPrimary(t *testing.T) {
+	if UsesPrimary(context.Background()) {
+		t.Error("UsesPrimary() of a plain context = true")
+	}
+	if !UsesPrimary(WithPrimary(context.Background())) {
+		t.Error("UsesPrimary() of a WithPrimary context = false")
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import "testing"
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		strong bool
+	}{
+		{name: "same strong tag", header: `"v1"`, etag: `"v1"`, weak: true, strong: true},
+		{name: "different tag", header: `"v2"`, etag: `"v1"`},
+		{name: "one of a list", header: `"v0", "v1" ,"v2"`, etag: `"v1"`, weak: true, strong: true},
+		{name: "wildcard", header: `*`, etag: `"v1"`, weak: true, strong: true},
+		{name: "weak header tag", header: `W/"v1"`, etag: `"v1"`, weak: true},
+		{name: "weak etag", header: `"v1"`, etag: `W/"v1"`, weak: true},
+		{name: "both weak", header: `W/"v1"`, etag: `W/"v1"`, weak: true},
+		{name: "unquoted", header: `v1`, etag: `"v1"`},
+		{name: "empty header", header: ``, etag: `"v1"`},
+		{name: "empty list entries", header: ` , `, etag: `""`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ETagMatches(test.header, test.etag); got != test.weak {
+				t.Errorf("ETagMatches(%q, %q) = %v, want %v", test.header, test.etag, got, test.weak)
+			}
+			if got := StrongETagMatches(test.header, test.etag); got != test.strong {
+				t.Errorf("StrongETagMatches(%q, %q) = %v, want %v", test.header, test.etag, got, test.strong)
+			}
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	tests := []struct{ value, want string }{
+		{"3", `"3"`},
+		{`a"b`, `"a\"b"`},
+	}
+	for _, test := range tests {
+		if got := ETag(test.value); got != test.want {
+			t.Errorf("ETag(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
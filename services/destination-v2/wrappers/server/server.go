@@ -1,22 +1,114 @@
 package server
 
 import (
+	stdContext "context"
 	"encoding/json"
 	"fmt"
 	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/pgxpool"
 	instana "github.com/instana/go-sensor"
 	"github.com/iris-contrib/middleware/cors"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/pkg/errors"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long Start waits for the requests in flight
+// when it stops.
+const shutdownTimeout = 10 * time.Second
+
 type RequestContext = iris.Context
 type RequestHandler = func(ctx RequestContext)
-type RouterInitializer = func(router PathRouter, pool database.Pool, sensor *instana.Sensor)
+
+// RouterInitializer registers the routes of the service and starts its
+// background work. An error stops Start.
+type RouterInitializer = func(router PathRouter, pool database.Pool, sensor *instana.Sensor) error
+
+// StartupCheck runs once the database is connected, before the routes are
+// registered. An error stops the web server and Start returns it.
+type StartupCheck = func(ctx stdContext.Context, pool database.Pool) error
+
+type Option func(options *startOptions)
+
+type startOptions struct {
+	checks     []StartupCheck
+	noDatabase bool
+}
+
+func WithStartupCheck(check StartupCheck) Option {
+	return func(options *startOptions) {
+		options.checks = append(options.checks, check)
+	}
+}
+
+// WithoutDatabase starts the server without connecting to the database. The
+// RouterInitializer gets a nil pool, and no startup check runs.
+func WithoutDatabase() Option {
+	return func(options *startOptions) {
+		options.noDatabase = true
+	}
+}
+
+// ResponseWithETag is Response for a representation identified by etag.
+// Clients can send it back in If-Match to make conditional updates, and in
+// If-None-Match to get a 304 when nothing changed.
+func ResponseWithETag(ctx iris.Context, code int, etag string, response interface{}) {
+	ctx.Header("ETag", etag)
+	if code == http.StatusOK && ETagMatches(ctx.GetHeader("If-None-Match"), etag) {
+		ctx.StatusCode(http.StatusNotModified)
+		return
+	}
+	Response(ctx, code, response)
+}
+
+// ETag quotes value as a strong entity tag.
+func ETag(value string) string {
+	return strconv.Quote(value)
+}
+
+// IfMatch returns the If-Match header of the request, if any.
+func IfMatch(ctx iris.Context) (string, bool) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	return header, header != ""
+}
+
+// ETagMatches reports whether a comma-separated If-None-Match header lists
+// etag, or is "*". It uses the weak comparison, so weak tags match their
+// strong counterpart.
+func ETagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || (candidate != "" && candidate == strings.TrimPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// StrongETagMatches reports whether a comma-separated If-Match header lists
+// etag, or is "*". It uses the strong comparison that If-Match requires
+// (RFC 9110, section 13.1.1): a weak tag never matches, not even itself.
+func StrongETagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if candidate != "" && !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
 
 func Response(ctx iris.Context, code int, response interface{}) {
 	ctx.Header("Content-Type", "application/json")
@@ -35,27 +127,131 @@ func Response(ctx iris.Context, code int, response interface{}) {
 	ctx.Write(data)
 }
 
-func Start(serviceName string, init RouterInitializer) error {
+// NewSensor creates the Instana sensor used to trace serviceName. Command
+// line tools pass a quieter logLevel than the web server.
+func NewSensor(serviceName string, logLevel int) *instana.Sensor {
 	tracer := instana.NewTracerWithOptions(
 		&instana.Options{
 			Service:           serviceName,
 			EnableAutoProfile: true,
-			LogLevel:          instana.Debug,
+			LogLevel:          logLevel,
 		},
 	)
 
-	sensor := instana.NewSensorWithTracer(tracer)
+	return instana.NewSensorWithTracer(tracer)
+}
+
+// Start listens right away, but answers every request with a 503 and
+// Retry-After until the database is connected, the startup checks have
+// passed and the routes are registered. /ready tells when that is done.
+func Start(serviceName string, init RouterInitializer, options ...Option) error {
+	var opts startOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	sensor := NewSensor(serviceName, instana.Debug)
+
+	port := ":9001"
+	if addr, ok := os.LookupEnv("PORT"); ok {
+		port = addr
+	}
+	address := fmt.Sprintf(":%s", port)
 
-	pool, err := database.NewDatabasePool(sensor)
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		return errors.Errorf("Database connection not available: %v", err)
+		return errors.Errorf("Couldn't start authentication server: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(stdContext.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Starting webserver...")
+	return serve(ctx, listener, func() (http.Handler, error) {
+		return prepare(sensor, init, opts)
+	})
+}
+
+// serve serves listener until ctx is done or prepare fails. Until prepare
+// returns the handler of the service, requests get a 503. The handler is
+// complete by then: no route is added while requests are being served.
+func serve(ctx stdContext.Context, listener net.Listener, prepare func() (http.Handler, error)) error {
+	var ready atomic.Value
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if handler, ok := ready.Load().(http.Handler); ok {
+				handler.ServeHTTP(w, req)
+				return
+			}
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		}),
+	}
+
+	failed := make(chan error, 1)
+	go func() {
+		handler, err := prepare()
+		if err != nil {
+			failed <- err
+			return
+		}
+		ready.Store(handler)
+		fmt.Println("Ready")
+	}()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	var startErr error
+	select {
+	case err := <-served:
+		return errors.Errorf("Couldn't start authentication server: %v", err)
+	case startErr = <-failed:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := stdContext.WithTimeout(stdContext.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && startErr == nil {
+		return errors.Errorf("Couldn't stop the webserver: %v", err)
+	}
+	return startErr
+}
+
+// prepare connects to the database, retrying until it is up, runs the
+// startup checks and builds the router of the service.
+func prepare(sensor *instana.Sensor, init RouterInitializer, opts startOptions) (*iris.Application, error) {
+	var pool database.Pool
+	if !opts.noDatabase {
+		var err error
+		pool, err = database.ConnectWithRetry(stdContext.Background(), sensor)
+		if err != nil {
+			return nil, errors.Errorf("Database connection not available: %v", err)
+		}
+
+		for _, check := range opts.checks {
+			ctx, cancel := stdContext.WithTimeout(stdContext.Background(), time.Second*20)
+			err := check(ctx, pool)
+			cancel()
+			if err != nil {
+				return nil, errors.Errorf("Startup check failed: %v", err)
+			}
+		}
 	}
 
 	app := iris.New()
 
 	// Add Instana tracer to all calls
 	app.WrapRouter(func(w http.ResponseWriter, req *http.Request, router http.HandlerFunc) {
-		adapter := instana.TracingHandlerFunc(sensor, "", router)
+		if readsOwnWrites(req) {
+			req = req.WithContext(pgxpool.WithPrimary(req.Context()))
+		}
+		req = req.WithContext(database.WithRouteClass(req.Context(), routeClass(req)))
+		adapter := instana.TracingHandlerFunc(sensor, "", func(traced http.ResponseWriter, req *http.Request) {
+			router(flushWriter{ResponseWriter: traced, flusher: w}, req)
+		})
 		adapter.ServeHTTP(w, req)
 	})
 
@@ -66,20 +262,50 @@ func Start(serviceName string, init RouterInitializer) error {
 	})
 
 	// Initialize user registered handlers
-	init(&innerRouter{party: app}, pool, sensor)
+	if err := init(&innerRouter{party: app}, pool, sensor); err != nil {
+		return nil, errors.Errorf("Couldn't initialize the service: %v", err)
+	}
+	if err := app.Build(); err != nil {
+		return nil, errors.Errorf("Couldn't build the router: %v", err)
+	}
+	return app, nil
+}
 
-	port := ":9001"
-	if addr, ok := os.LookupEnv("PORT"); ok {
-		port = addr
+// readsOwnWrites reports whether the reads of req must go to the primary:
+// requests that write always do, so that they check the state they change,
+// and others can ask for it with X-Read-Your-Writes, for example right after
+// a write.
+func readsOwnWrites(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Header.Get("X-Read-Your-Writes") == "true"
+	default:
+		return true
 	}
-	address := fmt.Sprintf(":%s", port)
+}
 
-	fmt.Println("Starting webserver...")
-	err = app.Listen(address)
-	if err != nil {
-		return errors.Errorf("Couldn't start authentication server: %v", err)
+// routeClass picks the bulkhead of the statements of req. Handlers can
+// narrow it down, as admin-only ones do.
+func routeClass(req *http.Request) database.RouteClass {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return database.ReadRoutes
+	default:
+		return database.WriteRoutes
+	}
+}
+
+// flushWriter restores the http.Flusher that the Instana wrapper hides, so
+// that streaming responses reach the client as they are written.
+type flushWriter struct {
+	http.ResponseWriter
+	flusher http.ResponseWriter
+}
+
+func (w flushWriter) Flush() {
+	if flusher, ok := w.flusher.(http.Flusher); ok {
+		flusher.Flush()
 	}
-	return nil
 }
 
 func newCors(methods ...string) RequestHandler {
@@ -100,10 +326,19 @@ func newCors(methods ...string) RequestHandler {
 			"Accept",
 			"Content-Type",
 			"Authorization",
+			"If-Match",
+			"If-None-Match",
+			"Last-Event-ID",
+			"X-Actor",
+			"X-Change-Reason",
+			"X-Read-Your-Writes",
 			"X-INSTANA-T",
 			"X-INSTANA-S",
 			"X-INSTANA-L",
 		},
+		ExposedHeaders: []string{
+			"ETag",
+		},
 	})
 }
 
@@ -188,12 +423,7 @@ func (ir innerRouter) Path(path string, init func(router PathRouter)) {
 			methods: make(map[string]*handlerRegistration),
 		}
 
-		pathTemplate := inner.GetRelPath()
-		for strings.Contains(pathTemplate, ":") {
-			index := strings.Index(pathTemplate, ":")
-			end := index + strings.Index(pathTemplate[index:], "}")
-			pathTemplate = pathTemplate[:index] + pathTemplate[end:]
-		}
+		pathTemplate := stripMacros(inner.GetRelPath())
 
 		pathTemplateAdapter := func(next RequestHandler) RequestHandler {
 			return func(ctx RequestContext) {
@@ -237,3 +467,30 @@ func (ir innerRouter) Path(path string, init func(router PathRouter)) {
 		iir.party.Options("", pathTemplateAdapter(cors))
 	})
 }
+
+// stripMacros turns "/{country:string}" into "/{country}". Colons outside of
+// parameters, as in "/destinations:import", are kept.
+func stripMacros(path string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(path, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(path[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+
+		param := path[start : end+1]
+		if colon := strings.Index(param, ":"); colon >= 0 {
+			param = param[:colon] + "}"
+		}
+		b.WriteString(path[:start])
+		b.WriteString(param)
+		path = path[end+1:]
+	}
+	b.WriteString(path)
+	return b.String()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	stdContext "context"
+	"fmt"
+	"github.com/bee-travels/bee-travels-go/services/destination-v2/wrappers/database"
+	instana "github.com/instana/go-sensor"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServe runs serve on a loopback port. It returns the URL of the server
+// and a channel that receives what serve returns.
+func startServe(t *testing.T, ctx stdContext.Context, prepare func() (http.Handler, error)) (string, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, listener, prepare)
+	}()
+	return "http://" + listener.Addr().String(), done
+}
+
+func waitServe(t *testing.T, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve didn't return")
+		return nil
+	}
+}
+
+func get(t *testing.T, url string) (int, string, http.Header) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(body)), resp.Header
+}
+
+func TestServe(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+
+	t.Run("answers 503 until prepared", func(t *testing.T) {
+		ctx, cancel := stdContext.WithCancel(stdContext.Background())
+		prepared := make(chan struct{})
+		url, done := startServe(t, ctx, func() (http.Handler, error) {
+			<-prepared
+			return ok, nil
+		})
+
+		for _, path := range []string{"/ready", "/api/v1/destinations"} {
+			status, _, header := get(t, url+path)
+			if status != http.StatusServiceUnavailable || header.Get("Retry-After") == "" {
+				t.Errorf("GET %s before ready: %d with Retry-After %q, want 503 with Retry-After", path, status, header.Get("Retry-After"))
+			}
+		}
+
+		close(prepared)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			status, body, _ := get(t, url+"/api/v1/destinations")
+			if status == http.StatusOK && body == "ok" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GET after ready: %d %q, want 200", status, body)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		cancel()
+		if err := waitServe(t, done); err != nil {
+			t.Errorf("serve() error = %v, want nil once stopped", err)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		prepare func() (http.Handler, error)
+		cancel  bool
+		wantErr string
+	}{
+		{
+			name:    "prepare fails",
+			prepare: func() (http.Handler, error) { return nil, errors.New("no database") },
+			wantErr: "no database",
+		},
+		{
+			name: "stopped while preparing",
+			prepare: func() (http.Handler, error) {
+				select {}
+			},
+			cancel: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := stdContext.WithCancel(stdContext.Background())
+			defer cancel()
+			url, done := startServe(t, ctx, test.prepare)
+			if test.cancel {
+				cancel()
+			}
+
+			err := waitServe(t, done)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("serve() error = %v, want nil", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("serve() error = %v, want %q", err, test.wantErr)
+			}
+			if _, err := http.Get(url + "/ready"); err == nil {
+				t.Error("the server still answers after serve returned")
+			}
+		})
+	}
+}
+
+func TestPrepareWithoutDatabase(t *testing.T) {
+	t.Run("initializer fails", func(t *testing.T) {
+		init := func(router PathRouter, pool database.Pool, sensor *instana.Sensor) error {
+			return errors.New("no broker")
+		}
+		if _, err := prepare(nil, init, startOptions{noDatabase: true}); err == nil || !strings.Contains(err.Error(), "no broker") {
+			t.Errorf("prepare() error = %v, want the initializer's", err)
+		}
+	})
+
+	t.Run("routes are registered", func(t *testing.T) {
+		var registered []string
+		init := func(router PathRouter, pool database.Pool, sensor *instana.Sensor) error {
+			if pool != nil {
+				t.Error("the initializer got a pool without a database")
+			}
+			router.Path("/api/v1/destinations", func(router PathRouter) {
+				router.Get(func(ctx RequestContext) {})
+				registered = append(registered, "/api/v1/destinations")
+			})
+			return nil
+		}
+		app, err := prepare(nil, init, startOptions{noDatabase: true})
+		if err != nil {
+			t.Fatalf("prepare() error = %v", err)
+		}
+		if len(registered) != 1 {
+			t.Fatalf("registered %q, want the destinations route", registered)
+		}
+		if route := app.GetRoute("GET/api/v1/destinations"); route == nil {
+			t.Error("the destinations route is missing from the router")
+		}
+	})
+}
@@ -0,0 +1,3 @@
+module github.com/bee-travels/bee-travels-go/services/shared
+
+go 1.16
@@ -0,0 +1,180 @@
+// Package validation holds the data-quality rules that both destination
+// services apply to their datasets, so that a dataset accepted by one is
+// accepted by the other.
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Rules reported by Check.
+const (
+	ParseError            = "parse-error"
+	MissingField          = "missing-required-field"
+	DuplicateID           = "duplicate-id"
+	DuplicateLocation     = "duplicate-location"
+	SlugCollision         = "slug-collision"
+	CoordinateRange       = "coordinate-out-of-range"
+	NonPositivePopulation = "non-positive-population"
+	EmptyDescription      = "empty-description"
+	MalformedImageURL     = "malformed-image-url"
+	DuplicateImageURL     = "duplicate-image-url"
+)
+
+// Destination holds the fields of a destination that the rules check. Each
+// service converts its own destination type to it.
+type Destination struct {
+	ID          string
+	City        string
+	Country     string
+	Latitude    float64
+	Longitude   float64
+	Population  int
+	Description string
+	Images      []string
+}
+
+// Item is a destination together with where it was read from.
+type Item struct {
+	Source      string
+	Index       int
+	Destination Destination
+}
+
+// Issue is a single data-quality problem.
+type Issue struct {
+	Rule    string `json:"rule"`
+	Source  string `json:"source,omitempty"`
+	Index   int    `json:"index"`
+	ID      string `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the machine-readable outcome of a validation run.
+type Report struct {
+	Sources []string       `json:"sources"`
+	Total   int            `json:"total"`
+	Valid   bool           `json:"valid"`
+	Counts  map[string]int `json:"counts"`
+	Issues  []Issue        `json:"issues"`
+}
+
+// Add records an issue.
+func (r *Report) Add(issue Issue) {
+	r.Issues = append(r.Issues, issue)
+	r.Counts[issue.Rule]++
+	r.Valid = false
+}
+
+// NewReport creates an empty, valid report.
+func NewReport(sources ...string) *Report {
+	return &Report{
+		Sources: sources,
+		Valid:   true,
+		Counts:  make(map[string]int),
+		Issues:  make([]Issue, 0),
+	}
+}
+
+// Check validates items in the order they would be layered. An id repeated
+// within one source is a duplicate; an id repeated in a later source is an
+// intentional override, and only the overriding destination is checked.
+func Check(report *Report, items []Item) {
+	type key struct{ source, id string }
+	seen := make(map[key]Item)
+	effective := make([]Item, 0, len(items))
+	position := make(map[string]int)
+
+	for _, item := range items {
+		id := item.Destination.ID
+		if prev, ok := seen[key{item.Source, id}]; ok && id != "" {
+			report.Add(issueFor(item, DuplicateID, "id already used at index %d", prev.Index))
+			continue
+		}
+		seen[key{item.Source, id}] = item
+
+		if i, ok := position[id]; ok && id != "" {
+			effective[i] = item
+			continue
+		}
+		position[id] = len(effective)
+		effective = append(effective, item)
+	}
+
+	report.Total = len(effective)
+
+	locations := make(map[string]Item)
+	slugs := make(map[string]Item)
+	images := make(map[string]Item)
+
+	for _, item := range effective {
+		d := item.Destination
+
+		if d.ID == "" || d.City == "" || d.Country == "" {
+			report.Add(issueFor(item, MissingField, "id, city and country are required"))
+		}
+
+		location := strings.ToLower(d.City) + "\x00" + strings.ToLower(d.Country)
+		if prev, ok := locations[location]; ok {
+			report.Add(issueFor(item, DuplicateLocation, "%s, %s is also defined by %s", d.City, d.Country, prev.Destination.ID))
+		} else {
+			locations[location] = item
+
+			slug := Slug(d.Country) + "/" + Slug(d.City)
+			if prev, ok := slugs[slug]; ok {
+				report.Add(issueFor(item, SlugCollision, "path %s is also used by %s, %s (%s)", slug, prev.Destination.City, prev.Destination.Country, prev.Destination.ID))
+			} else {
+				slugs[slug] = item
+			}
+		}
+
+		if d.Latitude < -90 || d.Latitude > 90 || d.Longitude < -180 || d.Longitude > 180 {
+			report.Add(issueFor(item, CoordinateRange, "coordinates (%g, %g) are out of range", d.Latitude, d.Longitude))
+		}
+
+		if d.Population <= 0 {
+			report.Add(issueFor(item, NonPositivePopulation, "population is %d", d.Population))
+		}
+
+		if strings.TrimSpace(d.Description) == "" {
+			report.Add(issueFor(item, EmptyDescription, "description is empty"))
+		}
+
+		for _, image := range d.Images {
+			if !validImageURL(image) {
+				report.Add(issueFor(item, MalformedImageURL, "image %q is not an absolute http(s) URL", image))
+				continue
+			}
+			if prev, ok := images[image]; ok {
+				report.Add(issueFor(item, DuplicateImageURL, "image %s is also used by %s", image, prev.Destination.ID))
+				continue
+			}
+			images[image] = item
+		}
+	}
+}
+
+// Slug turns a city or country name into the form used in URL paths.
+func Slug(name string) string {
+	return strings.Join(strings.Split(strings.ToLower(name), " "), "-")
+}
+
+func validImageURL(image string) bool {
+	u, err := url.Parse(image)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func issueFor(item Item, rule, format string, args ...interface{}) Issue {
+	return Issue{
+		Rule:    rule,
+		Source:  item.Source,
+		Index:   item.Index,
+		ID:      item.Destination.ID,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
@@ -0,0 +1,159 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func valid(id, city, country string) Destination {
+	return Destination{
+		ID:          id,
+		City:        city,
+		Country:     country,
+		Latitude:    10,
+		Longitude:   20,
+		Population:  1000,
+		Description: "A place",
+		Images:      []string{"https://example.com/" + id + ".jpg"},
+	}
+}
+
+func items(source string, destinations ...Destination) []Item {
+	res := make([]Item, len(destinations))
+	for i, d := range destinations {
+		res[i] = Item{Source: source, Index: i, Destination: d}
+	}
+	return res
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		items     []Item
+		wantTotal int
+		want      []string
+	}{
+		{
+			name:      "valid",
+			items:     items("a", valid("1", "Paris", "France"), valid("2", "Rome", "Italy")),
+			wantTotal: 2,
+		},
+		{
+			name:      "missing fields",
+			items:     items("a", valid("1", "", "France")),
+			wantTotal: 1,
+			want:      []string{MissingField},
+		},
+		{
+			name:      "duplicate id in one source",
+			items:     items("a", valid("1", "Paris", "France"), valid("1", "Rome", "Italy")),
+			wantTotal: 1,
+			want:      []string{DuplicateID},
+		},
+		{
+			name:      "same id in a later source overrides",
+			items:     append(items("a", valid("1", "Paris", "France")), items("b", valid("1", "Lyon", "France"))...),
+			wantTotal: 1,
+		},
+		{
+			name:      "duplicate location ignores case",
+			items:     items("a", valid("1", "Paris", "France"), valid("2", "paris", "FRANCE")),
+			wantTotal: 2,
+			want:      []string{DuplicateLocation},
+		},
+		{
+			name:      "slug collision",
+			items:     items("a", valid("1", "New York", "USA"), valid("2", "New-York", "USA")),
+			wantTotal: 2,
+			want:      []string{SlugCollision},
+		},
+		{
+			name: "coordinates out of range",
+			items: items("a", func() Destination {
+				d := valid("1", "Paris", "France")
+				d.Latitude = 91
+				return d
+			}(), func() Destination {
+				d := valid("2", "Rome", "Italy")
+				d.Longitude = -181
+				return d
+			}()),
+			wantTotal: 2,
+			want:      []string{CoordinateRange, CoordinateRange},
+		},
+		{
+			name: "non-positive population",
+			items: items("a", func() Destination {
+				d := valid("1", "Paris", "France")
+				d.Population = 0
+				return d
+			}()),
+			wantTotal: 1,
+			want:      []string{NonPositivePopulation},
+		},
+		{
+			name: "blank description",
+			items: items("a", func() Destination {
+				d := valid("1", "Paris", "France")
+				d.Description = "  "
+				return d
+			}()),
+			wantTotal: 1,
+			want:      []string{EmptyDescription},
+		},
+		{
+			name: "malformed image URLs",
+			items: items("a", func() Destination {
+				d := valid("1", "Paris", "France")
+				d.Images = []string{"paris.jpg", "ftp://example.com/paris.jpg", "https:///paris.jpg"}
+				return d
+			}()),
+			wantTotal: 1,
+			want:      []string{MalformedImageURL, MalformedImageURL, MalformedImageURL},
+		},
+		{
+			name: "duplicate image URL",
+			items: items("a", valid("1", "Paris", "France"), func() Destination {
+				d := valid("2", "Rome", "Italy")
+				d.Images = []string{"https://example.com/1.jpg"}
+				return d
+			}()),
+			wantTotal: 2,
+			want:      []string{DuplicateImageURL},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report := NewReport("a", "b")
+			Check(report, test.items)
+
+			var got []string
+			for _, issue := range report.Issues {
+				got = append(got, issue.Rule)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("issues = %v, want %v (%+v)", got, test.want, report.Issues)
+			}
+			if report.Valid != (len(test.want) == 0) {
+				t.Errorf("valid = %v with %d issues", report.Valid, len(report.Issues))
+			}
+			if report.Total != test.wantTotal {
+				t.Errorf("total = %d, want %d", report.Total, test.wantTotal)
+			}
+		})
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"Paris", "paris"},
+		{"New York", "new-york"},
+		{"Rio de Janeiro", "rio-de-janeiro"},
+	}
+	for _, test := range tests {
+		if got := Slug(test.name); got != test.want {
+			t.Errorf("Slug(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}